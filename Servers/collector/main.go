@@ -0,0 +1,86 @@
+// Command collector is a stand-in for an OpenTelemetry collector: it accepts
+// OTLP/HTTP JSON trace exports and prints one line per span.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID           string `json:"traceId"`
+				SpanID            string `json:"spanId"`
+				ParentSpanID      string `json:"parentSpanId"`
+				Name              string `json:"name"`
+				Kind              int    `json:"kind"`
+				StartTimeUnixNano string `json:"startTimeUnixNano"`
+				EndTimeUnixNano   string `json:"endTimeUnixNano"`
+				Attributes        []struct {
+					Key   string                     `json:"key"`
+					Value map[string]json.RawMessage `json:"value"`
+				} `json:"attributes"`
+				Status struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func main() {
+	port := "4318"
+	if len(os.Args) > 1 {
+		port = os.Args[1]
+	}
+
+	http.HandleFunc("/v1/traces", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					start, _ := strconv.ParseInt(span.StartTimeUnixNano, 10, 64)
+					end, _ := strconv.ParseInt(span.EndTimeUnixNano, 10, 64)
+
+					var attrs []string
+					for _, attr := range span.Attributes {
+						for _, value := range attr.Value {
+							attrs = append(attrs, attr.Key+"="+string(value))
+						}
+					}
+					status := ""
+					if span.Status.Code == 2 {
+						status = " ERROR(" + span.Status.Message + ")"
+					}
+					fmt.Printf("trace=%s span=%s parent=%s kind=%d %q %v%s %s\n",
+						span.TraceID, span.SpanID, span.ParentSpanID, span.Kind, span.Name,
+						time.Duration(end-start), status, strings.Join(attrs, " "))
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	})
+
+	log.Printf("OTLP collector stub listening on :%s/v1/traces", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
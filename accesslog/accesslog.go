@@ -0,0 +1,221 @@
+// Package accesslog writes one line per proxied request in JSON, Common or
+// Combined Log Format or a Go template, to stdout, a rotating file or syslog.
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"reverseproxy.com/proxy"
+)
+
+// Entry is one access log record. Its fields are also what templates can
+// refer to, e.g. {{.ClientIP}} or {{.Duration}}.
+type Entry struct {
+	Time            time.Time
+	ClientIP        string
+	Method          string
+	Host            string
+	Path            string
+	URI             string
+	Protocol        string
+	Status          int
+	Bytes           int64
+	Route           string
+	Backend         string
+	UpstreamLatency time.Duration
+	Retries         int
+	Duration        time.Duration
+	RequestID       string
+	Referer         string
+	UserAgent       string
+}
+
+type FileOptions struct {
+	Path        string
+	MaxSize     int64
+	RotateEvery time.Duration
+	MaxBackups  int
+}
+
+type SyslogOptions struct {
+	Network  string
+	Address  string
+	Tag      string
+	Facility string
+}
+
+type Options struct {
+	Format   string
+	Template string
+	Output   string
+	File     FileOptions
+	Syslog   SyslogOptions
+	// SampleRate is the fraction of requests logged, overridden per route
+	// by RouteSampleRates. Responses with a 5xx status are always logged.
+	SampleRate       float64
+	RouteSampleRates map[string]float64
+}
+
+type Logger struct {
+	format     formatter
+	sink       io.WriteCloser
+	sampleRate float64
+	routeRates map[string]float64
+
+	mux sync.Mutex
+}
+
+func New(opts Options) (*Logger, error) {
+	format, err := newFormatter(opts.Format, opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink io.WriteCloser
+	switch opts.Output {
+	case "", "stdout":
+		sink = nopCloser{os.Stdout}
+	case "file":
+		sink, err = openRotatingFile(opts.File)
+	case "syslog":
+		sink, err = dialSyslog(opts.Syslog)
+	default:
+		err = fmt.Errorf("unknown access log output %q", opts.Output)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logger{
+		format:     format,
+		sink:       sink,
+		sampleRate: opts.SampleRate,
+		routeRates: opts.RouteSampleRates,
+	}, nil
+}
+
+// Middleware logs every request served by next. It must run inside the
+// client IP middleware so the resolved client address is known.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := proxy.WithRequestInfo(r)
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		// Deferred so requests aborted with a panic, such as a backend
+		// hanging up mid-body, are logged too.
+		defer func() {
+			entry := Entry{
+				Time:            start,
+				ClientIP:        proxy.ClientIP(r),
+				Method:          r.Method,
+				Host:            r.Host,
+				Path:            r.URL.Path,
+				URI:             r.URL.RequestURI(),
+				Protocol:        r.Proto,
+				Status:          recorder.statusCode(),
+				Bytes:           recorder.bytes,
+				Route:           info.Route,
+				Backend:         info.Backend,
+				UpstreamLatency: info.UpstreamLatency,
+				Retries:         info.Retries,
+				Duration:        time.Since(start),
+				RequestID:       proxy.RequestID(r),
+				Referer:         r.Referer(),
+				UserAgent:       r.UserAgent(),
+			}
+			if l.sampled(entry) {
+				l.write(entry)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+func (l *Logger) sampled(entry Entry) bool {
+	if entry.Status >= 500 {
+		return true
+	}
+	rate := l.sampleRate
+	if routeRate, ok := l.routeRates[entry.Route]; ok {
+		rate = routeRate
+	}
+	return rate >= 1 || rand.Float64() < rate
+}
+
+func (l *Logger) write(entry Entry) {
+	line, err := l.format(entry)
+	if err != nil {
+		log.Printf("Access log: %v", err)
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, err := l.sink.Write(line); err != nil {
+		log.Printf("Access log: %v", err)
+	}
+}
+
+func (l *Logger) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.sink.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// responseRecorder captures the status and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints precede the real one.
+	if rr.status == 0 && code >= 200 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(p)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+func (rr *responseRecorder) Flush() {
+	http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. for the
+// protocol upgrades handled by httputil.ReverseProxy.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func (rr *responseRecorder) statusCode() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
@@ -0,0 +1,114 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+type formatter func(Entry) ([]byte, error)
+
+func newFormatter(format, text string) (formatter, error) {
+	switch format {
+	case "", "json":
+		return formatJSON, nil
+	case "common":
+		return func(e Entry) ([]byte, error) {
+			return []byte(commonLine(e) + "\n"), nil
+		}, nil
+	case "combined":
+		return func(e Entry) ([]byte, error) {
+			return []byte(fmt.Sprintf("%s %q %q\n", commonLine(e), e.Referer, e.UserAgent)), nil
+		}, nil
+	case "template":
+		return templateFormatter(text)
+	}
+	return nil, fmt.Errorf("unknown access log format %q", format)
+}
+
+type jsonEntry struct {
+	Time              string  `json:"time"`
+	ClientIP          string  `json:"client_ip"`
+	Method            string  `json:"method"`
+	Host              string  `json:"host"`
+	Path              string  `json:"path"`
+	Protocol          string  `json:"protocol"`
+	Status            int     `json:"status"`
+	Bytes             int64   `json:"bytes"`
+	Route             string  `json:"route"`
+	Backend           string  `json:"backend"`
+	UpstreamLatencyMS float64 `json:"upstream_latency_ms"`
+	Retries           int     `json:"retries"`
+	DurationMS        float64 `json:"duration_ms"`
+	RequestID         string  `json:"request_id"`
+	Referer           string  `json:"referer"`
+	UserAgent         string  `json:"user_agent"`
+}
+
+func formatJSON(e Entry) ([]byte, error) {
+	line, err := json.Marshal(jsonEntry{
+		Time:              e.Time.Format(time.RFC3339Nano),
+		ClientIP:          e.ClientIP,
+		Method:            e.Method,
+		Host:              e.Host,
+		Path:              e.Path,
+		Protocol:          e.Protocol,
+		Status:            e.Status,
+		Bytes:             e.Bytes,
+		Route:             e.Route,
+		Backend:           e.Backend,
+		UpstreamLatencyMS: milliseconds(e.UpstreamLatency),
+		Retries:           e.Retries,
+		DurationMS:        milliseconds(e.Duration),
+		RequestID:         e.RequestID,
+		Referer:           e.Referer,
+		UserAgent:         e.UserAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// commonLine renders the Common Log Format: host ident authuser [date]
+// "request" status bytes.
+func commonLine(e Entry) string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] %q %d %s",
+		e.ClientIP, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Protocol, e.Status, size)
+}
+
+// templateFormatter renders a text/template over Entry. The template is run
+// once against an empty entry so references to unknown fields fail at
+// startup instead of on every request.
+func templateFormatter(text string) (formatter, error) {
+	tmpl, err := template.New("access_log").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("access log template: %w", err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, Entry{}); err != nil {
+		return nil, fmt.Errorf("access log template: %w", err)
+	}
+
+	return func(e Entry) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, e); err != nil {
+			return nil, err
+		}
+		if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}, nil
+}
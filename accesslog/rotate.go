@@ -0,0 +1,136 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// rotatingFile is an append-only log file that is renamed to
+// <path>.<timestamp> once it reaches MaxSize bytes or at every multiple of
+// RotateEvery, keeping at most MaxBackups rotated files.
+type rotatingFile struct {
+	opts FileOptions
+	file *os.File
+	size int64
+	// nextRotation is when the current file is rotated by time; zero
+	// without time rotation.
+	nextRotation time.Time
+}
+
+func openRotatingFile(opts FileOptions) (*rotatingFile, error) {
+	rf := &rotatingFile{opts: opts}
+	if err := rf.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open(now time.Time) error {
+	file, err := os.OpenFile(rf.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	if rf.opts.RotateEvery > 0 {
+		rf.nextRotation = now.UTC().Truncate(rf.opts.RotateEvery).Add(rf.opts.RotateEvery)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	now := time.Now()
+	sizeExceeded := rf.opts.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.opts.MaxSize
+	timeElapsed := !rf.nextRotation.IsZero() && !now.Before(rf.nextRotation)
+	if timeElapsed && rf.size == 0 {
+		// Nothing to keep, only the next rotation moves.
+		rf.nextRotation = now.UTC().Truncate(rf.opts.RotateEvery).Add(rf.opts.RotateEvery)
+		timeElapsed = false
+	}
+	if sizeExceeded || timeElapsed {
+		if err := rf.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate(now time.Time) error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	backup := rf.opts.Path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.Rename(rf.opts.Path, backup); err != nil {
+		return err
+	}
+	rf.removeOldBackups()
+	return rf.open(now)
+}
+
+// removeOldBackups deletes the oldest rotated files beyond MaxBackups. The
+// timestamp suffix sorts chronologically.
+func (rf *rotatingFile) removeOldBackups() {
+	if rf.opts.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(escapeGlob(rf.opts.Path) + ".*")
+	if err != nil {
+		return
+	}
+	// Other files next to the log, such as access.log.bak, are not ours.
+	var backups []string
+	for _, match := range matches {
+		if isBackupName(rf.opts.Path, match) {
+			backups = append(backups, match)
+		}
+	}
+	if len(backups) <= rf.opts.MaxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-rf.opts.MaxBackups] {
+		os.Remove(backup)
+	}
+}
+
+// isBackupName reports whether name is path followed by a rotation
+// timestamp, as written by rotate.
+func isBackupName(path, name string) bool {
+	suffix, ok := strings.CutPrefix(filepath.Base(name), filepath.Base(path)+".")
+	if !ok || len(suffix) != len(backupTimeFormat) {
+		return false
+	}
+	_, err := time.Parse(backupTimeFormat, suffix)
+	return err == nil
+}
+
+// escapeGlob quotes the filepath.Match metacharacters in path.
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.file.Close()
+}
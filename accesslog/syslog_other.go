@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package accesslog
+
+import (
+	"errors"
+	"io"
+)
+
+func dialSyslog(opts SyslogOptions) (io.WriteCloser, error) {
+	return nil, errors.New("syslog output is not supported on this platform")
+}
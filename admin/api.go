@@ -6,44 +6,122 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
+	"reverseproxy.com/metrics"
 	"reverseproxy.com/proxy"
+	"reverseproxy.com/ratelimit"
 )
 
 
 type AdminAPI struct{
 	pool *proxy.ServerPool
+	routePools []routePool
+	auth *Authenticator
+	reloader Reloader
+	limiter *ratelimit.Limiter
 	mux sync.RWMutex
 }
 
+type routePool struct{
+	name string
+	pool *proxy.ServerPool
+}
+
 func NewAdminAPI(pool *proxy.ServerPool) *AdminAPI{
 	return &AdminAPI{
 		pool:pool,
 	}
 }
 
+func (a *AdminAPI) SetAuthenticator(auth *Authenticator){
+	a.auth = auth
+}
+
+func (a *AdminAPI) AddRoutePool(name string, pool *proxy.ServerPool){
+	a.mux.Lock()
+	a.routePools = append(a.routePools, routePool{name: name, pool: pool})
+	a.mux.Unlock()
+}
+
+// RemoveRoutePool forgets the pool of a route that no longer exists.
+func (a *AdminAPI) RemoveRoutePool(name string){
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for i, rp := range a.routePools{
+		if rp.name == name{
+			a.routePools = append(a.routePools[:i:i], a.routePools[i+1:]...)
+			return
+		}
+	}
+}
+
 func (a *AdminAPI) SetUpRoutes(mux *http.ServeMux){
-	mux.HandleFunc("/status", a.handleStatus)
-    mux.HandleFunc("/backends", a.handleBackends)
+	mux.HandleFunc("/status", a.protect(a.handleStatus))
+    mux.HandleFunc("/backends", a.protect(a.handleBackends))
+	mux.HandleFunc("/backends/{id}", a.protect(a.handleBackend))
+	mux.HandleFunc("/backends/{id}/drain", a.protect(a.handleDrain))
+	mux.HandleFunc("/reload", a.protect(a.handleReload))
+	mux.HandleFunc("/ratelimits", a.protect(a.handleRateLimits))
+	mux.HandleFunc("/ratelimits/{rule}", a.protect(a.handleRateLimit))
+	mux.HandleFunc("/metrics", a.protect(metrics.Default.Handler().ServeHTTP))
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+// poolFor returns the default pool, or the pool of the route named by the
+// "route" query parameter.
+func (a *AdminAPI) poolFor(r *http.Request) *proxy.ServerPool {
+	name := r.URL.Query().Get("route")
+	if name == "" {
+		return a.pool
+	}
+
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	for _, rp := range a.routePools {
+		if rp.name == name {
+			return rp.pool
+		}
+	}
+	return nil
 }
 
 type StatusResponse struct{
 	TotalBackends int `json:"total_backends"`
 	ActiveBackends int `json:"active_backends"`
 	Backends []BackendsStatus `json:"backends"`
+	Routes []RouteStatus `json:"routes,omitempty"`
+}
+
+type RouteStatus struct{
+	Name string `json:"name"`
+	TotalBackends int `json:"total_backends"`
+	ActiveBackends int `json:"active_backends"`
+	Backends []BackendsStatus `json:"backends"`
 }
 
 type BackendsStatus struct{
+	ID string `json:"id"`
 	URL string `json:"url"`
-	Alive bool `jon:"alive"`
+	Alive bool `json:"alive"`
 	CurrentConnections int64 `json:"current_connections"`
+	DrainState string `json:"drain_state"`
+	proxy.OutlierStatus
+	CircuitBreaker proxy.BreakerStatus `json:"circuit_breaker"`
 }
 
-type AddBackendsRequest struct{
+type DeleteBackendsRequest struct{
 	URL string `json:"url"`
 }
 
-type DeleteBackendsRequest struct{
+type DrainRequest struct{
+	Timeout string `json:"timeout"`
+}
+
+type DrainResponse struct{
+	ID string `json:"id"`
 	URL string `json:"url"`
+	proxy.DrainStatus
 }
 func (a *AdminAPI) handleStatus(w http.ResponseWriter, r *http.Request){
 	if r.Method != http.MethodGet{
@@ -51,40 +129,64 @@ func (a *AdminAPI) handleStatus(w http.ResponseWriter, r *http.Request){
 		return
 	}
 	a.mux.RLock()
-	defer a.mux.Unlock()
+	defer a.mux.RUnlock()
+
+	backends, activeCount := poolStatus(a.pool)
+
+	response := StatusResponse{
+		TotalBackends: len(backends),
+		ActiveBackends: activeCount,
+		Backends: backends,
+	}
+
+	for _, rp := range a.routePools{
+		routeBackends, routeActive := poolStatus(rp.pool)
+		response.Routes = append(response.Routes, RouteStatus{
+			Name: rp.name,
+			TotalBackends: len(routeBackends),
+			ActiveBackends: routeActive,
+			Backends: routeBackends,
+		})
+	}
+	w.Header().Set("Content-Type","application/json")
+	json.NewEncoder(w).Encode(response)
+	log.Printf("Status requested: %d/%d backends alive", activeCount,len(backends))
+}
+
+func poolStatus(pool *proxy.ServerPool) ([]BackendsStatus, int){
+	pool.Mux.RLock()
+	defer pool.Mux.RUnlock()
 
 	var backends []BackendsStatus
 	activeCount := 0
 
-	for _,backend :=  range a.pool.Backends{
+	for _,backend :=  range pool.Backends{
 		isAlive := backend.IsAlive()
 
 		status := BackendsStatus{
+			ID: backend.ID,
 			URL: backend.URL.String(),
 			Alive: isAlive,
-			CurrentConnections: backend.CurrentConns,
+			CurrentConnections: backend.GetCurrentConns(),
+			DrainState: backend.GetDrainStatus().State,
+			OutlierStatus: backend.GetOutlierStatus(),
+			CircuitBreaker: backend.GetBreakerStatus(),
 		}
 		backends = append(backends, status)
-		if isAlive{
+		if backend.IsAvailable(){
 			activeCount++
 		}
 	}
-
-	response := StatusResponse{
-		TotalBackends: len(a.pool.Backends),
-		ActiveBackends: activeCount,
-		Backends: backends,
-	}
-	w.Header().Set("Content-Type","application/json")
-	json.NewEncoder(w).Encode(response)
-	log.Printf("Status requested: %d/%d backends alive", activeCount,len(a.pool.Backends))
+	return backends, activeCount
 }
 
 
 func (a *AdminAPI) handleBackends( w http.ResponseWriter, r *http.Request){
 	switch r.Method{
+	case http.MethodGet:
+		a.handleListBackends(w,r)
 	case http.MethodPost:
-		a.handleAddBackend(w,r)
+		a.handleCreateBackend(w,r)
 	case http.MethodDelete:
 		a.handleDeleteBackend(w,r)
 	default:
@@ -92,42 +194,6 @@ func (a *AdminAPI) handleBackends( w http.ResponseWriter, r *http.Request){
 	}
 }
 
-func (a *AdminAPI) handleAddBackend(w http.ResponseWriter,r *http.Request){
-	var req AddBackendsRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil{
-		http.Error(w, "Invalid Json",http.StatusBadRequest)
-		return
-	}
-
-	parsedURL, err := url.Parse(req.URL)
-	 if err != nil{
-		http.Error(w,"Invalid URL", http.StatusBadRequest)
-	 }
-
-	 
-	 newBackend := proxy.Backend{
-		URL:          parsedURL,
-        Alive:        true,
-        CurrentConns: 0,
-	 }
-
-	 a.mux.Lock()
-	 a.pool.Backends = append(a.pool.Backends,&newBackend)
-	 a.mux.Unlock()
-
-	 log.Printf("Backend added: %s", parsedURL.String())
-
-	 w.Header().Set("Content-Type","application/json")
-
-	 w.WriteHeader(http.StatusCreated)
-	 json.NewEncoder(w).Encode(map[string]string{
-		"message": "Backend added successfully",
-        "url":     parsedURL.String(),
-	 })
-
-
-}
-
 func (a *AdminAPI) handleDeleteBackend(w http.ResponseWriter,r *http.Request){
 	var req DeleteBackendsRequest
 
@@ -142,32 +208,86 @@ func (a *AdminAPI) handleDeleteBackend(w http.ResponseWriter,r *http.Request){
         return
 	} 
 
-	a.mux.Lock()
-	defer a.mux.Unlock()
-
-	found := false
-	newBackends := make([]*proxy.Backend,0,len(a.pool.Backends))
-
-	for _,backend := range a.pool.Backends{
-		if backend.URL.String() ==  parsedURL.String(){
-			found = true
-			log.Printf("Backed removed: %s (had %d active connections)",
-		backend.URL.String(),backend.CurrentConns)
-		}else{
-			newBackends = append(newBackends, backend)
-		}
+	pool := a.poolFor(r)
+	if pool == nil{
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
 	}
 
-	if !found{
+	backend := pool.GetBackendByURL(parsedURL)
+	if backend == nil{
+		http.Error(w,"Backend not found", http.StatusNotFound)
+		return
+	}
+	if _, err := pool.RemoveBackendAfterDrain(backend.ID, defaultDrainTimeout); err != nil{
 		http.Error(w,"Backend not found", http.StatusNotFound)
 		return
 	}
 
-	a.pool.Backends = newBackends
+	log.Printf("Backend %s will be removed after draining %d active connection(s)",
+		backend.URL.String(),backend.GetCurrentConns())
 
 	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Backend removed successfully",
+		"message": "Backend is draining and will be removed",
         "url":     parsedURL.String(),
 	})
-}
\ No newline at end of file
+}
+
+func (a *AdminAPI) handleDrain(w http.ResponseWriter, r *http.Request){
+	pool := a.poolFor(r)
+	if pool == nil{
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	id := r.PathValue("id")
+
+	var backend *proxy.Backend
+	var err error
+
+	switch r.Method{
+	case http.MethodGet:
+		backend = pool.GetBackendByID(id)
+		if backend == nil{
+			err = proxy.ErrBackendNotFound
+		}
+	case http.MethodPost:
+		timeout := defaultDrainTimeout
+		var req DrainRequest
+		if r.ContentLength != 0{
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil{
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Timeout != ""{
+			timeout, err = time.ParseDuration(req.Timeout)
+			if err != nil || timeout <= 0{
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+		backend, err = pool.DrainBackend(id, timeout)
+	case http.MethodDelete:
+		backend, err = pool.UndrainBackend(id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil{
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type","application/json")
+	if r.Method == http.MethodPost{
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(DrainResponse{
+		ID: backend.ID,
+		URL: backend.URL.String(),
+		DrainStatus: backend.GetDrainStatus(),
+	})
+}
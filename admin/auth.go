@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	RoleReadOnly  = "read-only"
+	RoleReadWrite = "read-write"
+)
+
+type TokenCredential struct {
+	Name        string
+	TokenSHA256 string
+	Role        string
+}
+
+type BasicCredential struct {
+	Username string
+	// PasswordHash is a bcrypt or argon2id hash.
+	PasswordHash string
+	Role         string
+}
+
+type ClientCertIdentity struct {
+	CommonName string
+	Role       string
+}
+
+// Authenticator identifies admin API callers by verified client certificate,
+// bearer token or basic credentials. Tokens are only stored as SHA-256 hex
+// digests and passwords as bcrypt or argon2id hashes, all compared in
+// constant time.
+type Authenticator struct {
+	Tokens      []TokenCredential
+	Users       []BasicCredential
+	ClientCerts []ClientCertIdentity
+}
+
+type caller struct {
+	identity string
+	role     string
+}
+
+func (auth *Authenticator) Enabled() bool {
+	return len(auth.Tokens) > 0 || len(auth.Users) > 0 || len(auth.ClientCerts) > 0
+}
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func digestEqual(value, expectedHex string) bool {
+	return subtle.ConstantTimeCompare([]byte(sha256Hex(value)), []byte(strings.ToLower(expectedHex))) == 1
+}
+
+func (auth *Authenticator) identify(r *http.Request) *caller {
+	// The TLS handshake already verified the chain against the client CA.
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, identity := range auth.ClientCerts {
+			if identity.CommonName == commonName {
+				return &caller{identity: "cert:" + commonName, role: identity.Role}
+			}
+		}
+	}
+
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		for _, credential := range auth.Tokens {
+			if digestEqual(token, credential.TokenSHA256) {
+				return &caller{identity: "token:" + credential.Name, role: credential.Role}
+			}
+		}
+		return nil
+	}
+
+	if username, password, ok := r.BasicAuth(); ok && len(auth.Users) > 0 {
+		if credential := auth.verifyUser(username, password); credential != nil {
+			return &caller{identity: "user:" + username, role: credential.Role}
+		}
+	}
+	return nil
+}
+
+// verifyUser checks basic credentials without revealing through timing
+// whether the username exists: every username is compared, and a password
+// is always verified, against the first user's hash when none matched.
+func (auth *Authenticator) verifyUser(username, password string) *BasicCredential {
+	match := -1
+	for i, credential := range auth.Users {
+		equal := subtle.ConstantTimeCompare([]byte(credential.Username), []byte(username))
+		match = subtle.ConstantTimeSelect(equal, i, match)
+	}
+
+	hash := auth.Users[0].PasswordHash
+	if match >= 0 {
+		hash = auth.Users[match].PasswordHash
+	}
+	verified := verifyPassword(password, hash)
+	if !verified || match < 0 {
+		return nil
+	}
+	return &auth.Users[match]
+}
+
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// protect authenticates and authorizes every call and writes an audit line
+// for each mutating one, including rejected attempts.
+func (a *AdminAPI) protect(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := "anonymous"
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		if !isReadOnlyMethod(r.Method) {
+			defer func() {
+				log.Printf("AUDIT caller=%s remote=%s method=%s path=%s status=%d",
+					identity, r.RemoteAddr, r.Method, r.URL.RequestURI(), recorder.status)
+			}()
+		}
+
+		if a.auth == nil || !a.auth.Enabled() {
+			handler(recorder, r)
+			return
+		}
+
+		c := a.auth.identify(r)
+		if c == nil {
+			recorder.Header().Set("WWW-Authenticate", `Bearer realm="admin", Basic realm="admin"`)
+			http.Error(recorder, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		identity = c.identity
+
+		if !isReadOnlyMethod(r.Method) && c.role != RoleReadWrite {
+			http.Error(recorder, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler(recorder, r)
+	}
+}
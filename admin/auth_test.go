@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func argon2idDigest(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	for _, hash := range []string{bcryptHash(t, "secret"), argon2idDigest("secret")} {
+		if err := CheckPasswordHash(hash); err != nil {
+			t.Errorf("CheckPasswordHash(%q): %v", hash, err)
+		}
+	}
+	for _, hash := range []string{
+		"",
+		"2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		"$argon2i$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$aGFzaA",
+		"$2b$04$short",
+	} {
+		if err := CheckPasswordHash(hash); err == nil {
+			t.Errorf("CheckPasswordHash(%q) accepted an unsupported hash", hash)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	auth := &Authenticator{Users: []BasicCredential{
+		{Username: "alice", PasswordHash: bcryptHash(t, "alice-secret"), Role: RoleReadOnly},
+		{Username: "bob", PasswordHash: argon2idDigest("bob-secret"), Role: RoleReadWrite},
+	}}
+
+	tests := []struct {
+		username, password string
+		identity, role     string
+	}{
+		{"alice", "alice-secret", "user:alice", RoleReadOnly},
+		{"bob", "bob-secret", "user:bob", RoleReadWrite},
+		{"alice", "bob-secret", "", ""},
+		{"bob", "alice-secret", "", ""},
+		// An unknown user is verified against the first user's hash, which
+		// must not let the first user's password through.
+		{"mallory", "alice-secret", "", ""},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/status", nil)
+		r.SetBasicAuth(tt.username, tt.password)
+		c := auth.identify(r)
+		switch {
+		case tt.identity == "" && c != nil:
+			t.Errorf("%s/%s: authenticated as %s", tt.username, tt.password, c.identity)
+		case tt.identity != "" && c == nil:
+			t.Errorf("%s/%s: rejected", tt.username, tt.password)
+		case c != nil && (c.identity != tt.identity || c.role != tt.role):
+			t.Errorf("%s/%s: got %s (%s), want %s (%s)", tt.username, tt.password, c.identity, c.role, tt.identity, tt.role)
+		}
+	}
+}
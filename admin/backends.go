@@ -0,0 +1,198 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"reverseproxy.com/proxy"
+)
+
+type BackendResource struct {
+	ID                 string              `json:"id"`
+	URL                string              `json:"url"`
+	Weight             int                 `json:"weight"`
+	Enabled            bool                `json:"enabled"`
+	Alive              bool                `json:"alive"`
+	Available          bool                `json:"available"`
+	MaxConns           int64               `json:"max_conns"`
+	Tags               []string            `json:"tags"`
+	Metadata           map[string]string   `json:"metadata"`
+	CurrentConnections int64               `json:"current_connections"`
+	EWMALatency        string              `json:"ewma_latency"`
+	Drain              proxy.DrainStatus   `json:"drain"`
+	Outlier            proxy.OutlierStatus `json:"outlier"`
+	CircuitBreaker     proxy.BreakerStatus `json:"circuit_breaker"`
+}
+
+type CreateBackendRequest struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight"`
+	Enabled  *bool             `json:"enabled"`
+	MaxConns int64             `json:"max_conns"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func backendResource(pool *proxy.ServerPool, backend *proxy.Backend) BackendResource {
+	maxConns, tags, metadata := backend.GetAttributes()
+
+	pool.Mux.RLock()
+	weight := backend.Weight
+	pool.Mux.RUnlock()
+
+	return BackendResource{
+		ID:                 backend.ID,
+		URL:                backend.URL.String(),
+		Weight:             weight,
+		Enabled:            backend.IsEnabled(),
+		Alive:              backend.IsAlive(),
+		Available:          backend.IsAvailable(),
+		MaxConns:           maxConns,
+		Tags:               tags,
+		Metadata:           metadata,
+		CurrentConnections: backend.GetCurrentConns(),
+		EWMALatency:        backend.GetEWMALatency().Round(time.Microsecond).String(),
+		Drain:              backend.GetDrainStatus(),
+		Outlier:            backend.GetOutlierStatus(),
+		CircuitBreaker:     backend.GetBreakerStatus(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseBackendURL only accepts absolute http(s) URLs, which is what the
+// reverse proxy can forward to.
+func parseBackendURL(raw string) (*url.URL, error) {
+	parsedURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, errors.New("scheme must be http or https")
+	}
+	if parsedURL.Host == "" {
+		return nil, errors.New("host is missing")
+	}
+	return parsedURL, nil
+}
+
+func (a *AdminAPI) handleListBackends(w http.ResponseWriter, r *http.Request) {
+	pool := a.poolFor(r)
+	if pool == nil {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	pool.Mux.RLock()
+	backends := append([]*proxy.Backend(nil), pool.Backends...)
+	pool.Mux.RUnlock()
+
+	resources := make([]BackendResource, 0, len(backends))
+	for _, backend := range backends {
+		resources = append(resources, backendResource(pool, backend))
+	}
+	writeJSON(w, http.StatusOK, resources)
+}
+
+func (a *AdminAPI) handleCreateBackend(w http.ResponseWriter, r *http.Request) {
+	pool := a.poolFor(r)
+	if pool == nil {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	var req CreateBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	parsedURL, err := parseBackendURL(req.URL)
+	if err != nil {
+		http.Error(w, "Invalid URL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Weight < 0 || req.MaxConns < 0 {
+		http.Error(w, "weight and max_conns cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	backend := &proxy.Backend{
+		URL:      parsedURL,
+		Alive:    true,
+		Weight:   req.Weight,
+		MaxConns: req.MaxConns,
+		Tags:     req.Tags,
+		Metadata: req.Metadata,
+	}
+	if err := pool.CreateBackend(backend, req.Enabled == nil || *req.Enabled); err != nil {
+		http.Error(w, "Backend already exists", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Backend added: %s (id: %s, weight: %d)", parsedURL.String(), backend.ID, backend.Weight)
+	w.Header().Set("Location", "/backends/"+backend.ID)
+	writeJSON(w, http.StatusCreated, backendResource(pool, backend))
+}
+
+func (a *AdminAPI) handleBackend(w http.ResponseWriter, r *http.Request) {
+	pool := a.poolFor(r)
+	if pool == nil {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		backend := pool.GetBackendByID(id)
+		if backend == nil {
+			http.Error(w, "Backend not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, backendResource(pool, backend))
+
+	case http.MethodPatch:
+		var update proxy.BackendUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if (update.Weight != nil && *update.Weight < 1) || (update.MaxConns != nil && *update.MaxConns < 0) {
+			http.Error(w, "weight must be at least 1 and max_conns cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		backend, err := pool.UpdateBackend(id, update)
+		if err != nil {
+			http.Error(w, "Backend not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Backend updated: %s", backend.URL.String())
+		writeJSON(w, http.StatusOK, backendResource(pool, backend))
+
+	case http.MethodDelete:
+		backend, err := pool.RemoveBackendAfterDrain(id, defaultDrainTimeout)
+		if err != nil {
+			http.Error(w, "Backend not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Backend %s will be removed after draining %d active connection(s)", backend.URL.String(), backend.GetCurrentConns())
+		writeJSON(w, http.StatusAccepted, DrainResponse{
+			ID:          backend.ID,
+			URL:         backend.URL.String(),
+			DrainStatus: backend.GetDrainStatus(),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
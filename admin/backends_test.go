@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverseproxy.com/proxy"
+)
+
+func newTestAdmin(t *testing.T) (*proxy.ServerPool, http.Handler) {
+	t.Helper()
+	pool := &proxy.ServerPool{}
+	mux := http.NewServeMux()
+	NewAdminAPI(pool).SetUpRoutes(mux)
+	return pool, mux
+}
+
+func call(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestCreateBackendConcurrently(t *testing.T) {
+	pool, handler := newTestAdmin(t)
+
+	const n = 20
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = call(handler, http.MethodPost, "/backends", `{"url":"http://a.test:8080"}`).Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("status %d", code)
+		}
+	}
+	if created != 1 || len(pool.Backends) != 1 {
+		t.Fatalf("%d created, %d in the pool, want 1 and 1", created, len(pool.Backends))
+	}
+}
+
+func TestCreateBackendDisabled(t *testing.T) {
+	pool, handler := newTestAdmin(t)
+
+	w := call(handler, http.MethodPost, "/backends", `{"url":"http://a.test:8080","enabled":false}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"enabled":false`) {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	backend := pool.Backends[0]
+	if backend.IsEnabled() || backend.IsAvailable() || pool.GetNextValidPeer() != nil {
+		t.Fatal("backend created disabled can take traffic")
+	}
+}
+
+func waitRemoved(t *testing.T, pool *proxy.ServerPool, id string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.GetBackendByID(id) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("backend not removed after draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeleteBackendDrainsFirst(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		delete func(handler http.Handler, backend *proxy.Backend) *httptest.ResponseRecorder
+	}{
+		{"by id", func(handler http.Handler, backend *proxy.Backend) *httptest.ResponseRecorder {
+			return call(handler, http.MethodDelete, "/backends/"+backend.ID, "")
+		}},
+		{"by url", func(handler http.Handler, backend *proxy.Backend) *httptest.ResponseRecorder {
+			return call(handler, http.MethodDelete, "/backends", `{"url":"`+backend.URL.String()+`"}`)
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, handler := newTestAdmin(t)
+			call(handler, http.MethodPost, "/backends", `{"url":"http://a.test:8080"}`)
+			backend := pool.Backends[0]
+
+			// A request is in flight when the backend is deleted.
+			backend.IncrementConnections()
+			if w := tt.delete(handler, backend); w.Code != http.StatusAccepted {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if pool.GetBackendByID(backend.ID) == nil {
+				t.Fatal("backend removed while a request is in flight")
+			}
+			if backend.IsAvailable() {
+				t.Fatal("deleted backend still takes new requests")
+			}
+
+			time.Sleep(3 * 100 * time.Millisecond)
+			if pool.GetBackendByID(backend.ID) == nil {
+				t.Fatal("backend removed while a request is in flight")
+			}
+			backend.DecrementConnections()
+			waitRemoved(t, pool, backend.ID)
+		})
+	}
+}
+
+func TestDeleteUnknownBackend(t *testing.T) {
+	_, handler := newTestAdmin(t)
+	if w := call(handler, http.MethodDelete, "/backends/nope", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("status %d", w.Code)
+	}
+}
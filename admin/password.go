@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnsupportedHash = errors.New("unsupported password hash: use bcrypt ($2a$, $2b$, $2y$) or argon2id ($argon2id$)")
+
+// argon2idHash is a parsed PHC string of the form
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<hash>.
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+func parseArgon2id(encoded string) (argon2idHash, error) {
+	var h argon2idHash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return h, errUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil || h.time == 0 || h.threads == 0 {
+		return h, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, errors.New("invalid argon2id salt")
+	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.hash) == 0 {
+		return h, errors.New("invalid argon2id hash")
+	}
+	return h, nil
+}
+
+// CheckPasswordHash reports whether encoded is a bcrypt or argon2id hash
+// the admin API can verify passwords against. Configuration validation uses
+// it, so the loader and the verifier agree on what a valid hash is.
+func CheckPasswordHash(encoded string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$2"):
+		_, err := bcrypt.Cost([]byte(encoded))
+		return err
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		_, err := parseArgon2id(encoded)
+		return err
+	}
+	return errUnsupportedHash
+}
+
+func verifyPassword(password, encoded string) bool {
+	if strings.HasPrefix(encoded, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	return subtle.ConstantTimeCompare(key, h.hash) == 1
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"reverseproxy.com/ratelimit"
+)
+
+func (a *AdminAPI) SetRateLimiter(limiter *ratelimit.Limiter) {
+	a.limiter = limiter
+}
+
+// handleRateLimits lists the rate limit rules with their number of tracked
+// keys.
+func (a *AdminAPI) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	if a.limiter == nil {
+		http.Error(w, "Rate limiting not supported", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.limiter.Rules())
+}
+
+// handleRateLimit shows the counters of every key of a rule, or of the one
+// given by the "key" query parameter, on GET. DELETE resets that key so its
+// next request starts at the full limit. API keys may be given in clear.
+func (a *AdminAPI) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	if a.limiter == nil {
+		http.Error(w, "Rate limiting not supported", http.StatusNotImplemented)
+		return
+	}
+	rule := r.PathValue("rule")
+	key, hasKey := r.URL.Query()["key"]
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		if !hasKey {
+			var keys []ratelimit.KeyStatus
+			if keys, err = a.limiter.Keys(rule); err == nil {
+				writeJSON(w, http.StatusOK, keys)
+				return
+			}
+			break
+		}
+		var status ratelimit.KeyStatus
+		if status, err = a.limiter.Key(rule, key[0]); err == nil {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+	case http.MethodDelete:
+		if !hasKey {
+			http.Error(w, "Missing key parameter", http.StatusBadRequest)
+			return
+		}
+		if err = a.limiter.Reset(rule, key[0]); err == nil {
+			log.Printf("Rate limit of %q reset for rule %s", key[0], rule)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ratelimit.ErrUnknownRule):
+		http.Error(w, "Rule not found", http.StatusNotFound)
+	case errors.Is(err, ratelimit.ErrUnknownKey):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
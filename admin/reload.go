@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+)
+
+// ReloadReport is the outcome of a configuration reload.
+type ReloadReport struct {
+	Trigger         string    `json:"trigger"`
+	Time            time.Time `json:"time"`
+	Success         bool      `json:"success"`
+	Error           string    `json:"error,omitempty"`
+	Changes         []string  `json:"changes"`
+	RestartRequired []string  `json:"restart_required,omitempty"`
+}
+
+type Reloader interface {
+	Reload(trigger string) ReloadReport
+	LastReload() (ReloadReport, bool)
+}
+
+func (a *AdminAPI) SetReloader(reloader Reloader) {
+	a.reloader = reloader
+}
+
+// handleReload re-reads the configuration on POST and reports the last
+// reload on GET. A rejected configuration leaves the running one in place
+// and is answered with 422.
+func (a *AdminAPI) handleReload(w http.ResponseWriter, r *http.Request) {
+	if a.reloader == nil {
+		http.Error(w, "Reload not supported", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		report, ok := a.reloader.LastReload()
+		if !ok {
+			http.Error(w, "No reload has happened yet", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	case http.MethodPost:
+		report := a.reloader.Reload("admin")
+		status := http.StatusOK
+		if !report.Success {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, report)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
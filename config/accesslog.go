@@ -0,0 +1,180 @@
+package config
+
+import (
+	"sort"
+	"text/template"
+	"time"
+)
+
+type AccessLogFileConfig struct {
+	Path string `json:"path"`
+	// MaxSizeMB rotates the file once it would grow past this size; 0
+	// disables size rotation.
+	MaxSizeMB int `json:"max_size_mb"`
+	// RotateEvery rotates the file at multiples of this interval (UTC); 0
+	// disables time rotation.
+	RotateEvery time.Duration `json:"rotate_every"`
+	// MaxBackups is the number of rotated files kept; 0 keeps all of them.
+	MaxBackups int `json:"max_backups"`
+}
+
+// AccessLogSyslogConfig leaves Network and Address empty to log to the local
+// syslog daemon.
+type AccessLogSyslogConfig struct {
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Tag      string `json:"tag"`
+	Facility string `json:"facility"`
+}
+
+type AccessLogConfig struct {
+	Enabled  bool                  `json:"enabled"`
+	Format   string                `json:"format"`
+	Template string                `json:"template"`
+	Output   string                `json:"output"`
+	File     AccessLogFileConfig   `json:"file"`
+	Syslog   AccessLogSyslogConfig `json:"syslog"`
+	// SampleRate is the fraction of requests logged, overridden per route
+	// by RouteSampleRates. Server errors are always logged.
+	SampleRate       float64            `json:"sample_rate"`
+	RouteSampleRates map[string]float64 `json:"route_sample_rates"`
+}
+
+type accessLogJSON struct {
+	Enabled  bool   `json:"enabled"`
+	Format   string `json:"format"`
+	Template string `json:"template"`
+	Output   string `json:"output"`
+	File     struct {
+		Path        string `json:"path"`
+		MaxSizeMB   *int   `json:"max_size_mb"`
+		RotateEvery string `json:"rotate_every"`
+		MaxBackups  *int   `json:"max_backups"`
+	} `json:"file"`
+	Syslog           AccessLogSyslogConfig `json:"syslog"`
+	SampleRate       *float64              `json:"sample_rate"`
+	RouteSampleRates map[string]float64    `json:"route_sample_rates"`
+}
+
+var defaultAccessLog = AccessLogConfig{
+	Format:     "json",
+	Output:     "stdout",
+	File:       AccessLogFileConfig{MaxSizeMB: 100, MaxBackups: 5},
+	Syslog:     AccessLogSyslogConfig{Tag: "reverse-proxy", Facility: "local0"},
+	SampleRate: 1,
+}
+
+func (a *accessLogJSON) toConfig() (AccessLogConfig, error) {
+	accessLog := defaultAccessLog
+	accessLog.Enabled = a.Enabled
+	if a.Format != "" {
+		accessLog.Format = a.Format
+	}
+	accessLog.Template = a.Template
+	if a.Output != "" {
+		accessLog.Output = a.Output
+	}
+
+	accessLog.File.Path = a.File.Path
+	if a.File.MaxSizeMB != nil {
+		accessLog.File.MaxSizeMB = *a.File.MaxSizeMB
+	}
+	if a.File.MaxBackups != nil {
+		accessLog.File.MaxBackups = *a.File.MaxBackups
+	}
+	var errs fieldErrors
+	if a.File.RotateEvery != "" {
+		rotateEvery, err := time.ParseDuration(a.File.RotateEvery)
+		if err != nil {
+			errs.add("file.rotate_every", "invalid duration %q", a.File.RotateEvery)
+		}
+		accessLog.File.RotateEvery = rotateEvery
+	}
+
+	accessLog.Syslog.Network = a.Syslog.Network
+	accessLog.Syslog.Address = a.Syslog.Address
+	if a.Syslog.Tag != "" {
+		accessLog.Syslog.Tag = a.Syslog.Tag
+	}
+	if a.Syslog.Facility != "" {
+		accessLog.Syslog.Facility = a.Syslog.Facility
+	}
+
+	if a.SampleRate != nil {
+		accessLog.SampleRate = *a.SampleRate
+	}
+	accessLog.RouteSampleRates = a.RouteSampleRates
+	return accessLog, errs.err()
+}
+
+var syslogFacilities = map[string]bool{
+	"kern": true, "user": true, "mail": true, "daemon": true, "auth": true, "syslog": true,
+	"lpr": true, "news": true, "uucp": true, "cron": true, "authpriv": true, "ftp": true,
+	"local0": true, "local1": true, "local2": true, "local3": true,
+	"local4": true, "local5": true, "local6": true, "local7": true,
+}
+
+func (a *AccessLogConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+
+	var errs fieldErrors
+
+	switch a.Format {
+	case "json", "common", "combined":
+	case "template":
+		if a.Template == "" {
+			errs.add("template", "must be specified when format is 'template'")
+		} else if _, err := template.New("access_log").Parse(a.Template); err != nil {
+			errs.add("template", "%v", err)
+		}
+	default:
+		errs.add("format", "must be 'json', 'common', 'combined' or 'template'")
+	}
+
+	switch a.Output {
+	case "stdout":
+	case "file":
+		if a.File.Path == "" {
+			errs.add("file.path", "must be specified when output is 'file'")
+		}
+		if a.File.MaxSizeMB < 0 {
+			errs.add("file.max_size_mb", "cannot be negative")
+		}
+		if a.File.RotateEvery < 0 {
+			errs.add("file.rotate_every", "cannot be negative")
+		}
+		if a.File.MaxBackups < 0 {
+			errs.add("file.max_backups", "cannot be negative")
+		}
+	case "syslog":
+		if (a.Syslog.Network == "") != (a.Syslog.Address == "") {
+			errs.add("syslog", "requires both network and address, or neither for the local daemon")
+		}
+		if a.Syslog.Network != "" && a.Syslog.Network != "udp" && a.Syslog.Network != "tcp" && a.Syslog.Network != "unix" {
+			errs.add("syslog.network", "must be 'udp', 'tcp' or 'unix'")
+		}
+		if !syslogFacilities[a.Syslog.Facility] {
+			errs.add("syslog.facility", "unknown facility %q", a.Syslog.Facility)
+		}
+	default:
+		errs.add("output", "must be 'stdout', 'file' or 'syslog'")
+	}
+
+	if a.SampleRate < 0 || a.SampleRate > 1 {
+		errs.add("sample_rate", "must be between 0 and 1")
+	}
+	routes := make([]string, 0, len(a.RouteSampleRates))
+	for route := range a.RouteSampleRates {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		if rate := a.RouteSampleRates[route]; rate < 0 || rate > 1 {
+			errs.add(joinPath("route_sample_rates", route), "must be between 0 and 1")
+		}
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,105 @@
+package config
+
+import "time"
+
+type CircuitBreakerConfig struct {
+	Enabled             bool    `json:"enabled"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	FailureRate         float64 `json:"failure_rate"`
+	MinRequests         int     `json:"min_requests"`
+	// Window is the period failure_rate is measured over.
+	Window       time.Duration `json:"window"`
+	OpenDuration time.Duration `json:"open_duration"`
+	// HalfOpenRequests trial requests must succeed to close the circuit.
+	HalfOpenRequests int `json:"half_open_requests"`
+}
+
+type circuitBreakerJSON struct {
+	Enabled             bool     `json:"enabled"`
+	ConsecutiveFailures *int     `json:"consecutive_failures"`
+	FailureRate         *float64 `json:"failure_rate"`
+	MinRequests         *int     `json:"min_requests"`
+	Window              string   `json:"window"`
+	OpenDuration        string   `json:"open_duration"`
+	HalfOpenRequests    *int     `json:"half_open_requests"`
+}
+
+var defaultCircuitBreaker = CircuitBreakerConfig{
+	ConsecutiveFailures: 5,
+	FailureRate:         0.5,
+	MinRequests:         20,
+	Window:              30 * time.Second,
+	OpenDuration:        30 * time.Second,
+	HalfOpenRequests:    3,
+}
+
+func (c *circuitBreakerJSON) toConfig() (CircuitBreakerConfig, error) {
+	breaker := defaultCircuitBreaker
+	breaker.Enabled = c.Enabled
+	if c.ConsecutiveFailures != nil {
+		breaker.ConsecutiveFailures = *c.ConsecutiveFailures
+	}
+	if c.FailureRate != nil {
+		breaker.FailureRate = *c.FailureRate
+	}
+	if c.MinRequests != nil {
+		breaker.MinRequests = *c.MinRequests
+	}
+	if c.HalfOpenRequests != nil {
+		breaker.HalfOpenRequests = *c.HalfOpenRequests
+	}
+
+	var errs fieldErrors
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"window", c.Window, &breaker.Window},
+		{"open_duration", c.OpenDuration, &breaker.OpenDuration},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			errs.add(d.name, "invalid duration %q", d.value)
+			continue
+		}
+		*d.dest = parsed
+	}
+	return breaker, errs.err()
+}
+
+func (c *CircuitBreakerConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var errs fieldErrors
+
+	if c.ConsecutiveFailures < 0 {
+		errs.add("consecutive_failures", "cannot be negative")
+	} else if c.ConsecutiveFailures == 0 && c.FailureRate == 0 {
+		errs.add("consecutive_failures", "must be positive when failure_rate is 0, or the circuit never opens")
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		errs.add("failure_rate", "must be between 0 and 1")
+	}
+	if c.MinRequests < 0 {
+		errs.add("min_requests", "cannot be negative")
+	}
+	// Same bucketed window, and minimum, as outlier detection.
+	if c.Window < time.Second {
+		errs.add("window", "must be at least 1s")
+	}
+	if c.OpenDuration <= 0 {
+		errs.add("open_duration", "must be positive")
+	}
+	if c.HalfOpenRequests < 1 {
+		errs.add("half_open_requests", "must be at least 1")
+	}
+
+	return errs.err()
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/url"
+	"net"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"reverseproxy.com/admin"
 )
 
 type BackendConfig struct {
@@ -21,6 +26,490 @@ type SSLConfig struct {
 	KeyFile  string `json:"key_file"`
 }
 
+type ForwardingConfig struct {
+	HostHeader   string   `json:"host_header"`
+	XForwarded   string   `json:"x_forwarded"`
+	Forwarded    string   `json:"forwarded"`
+	XRealIP      bool     `json:"x_real_ip"`
+	StripHeaders []string `json:"strip_headers"`
+}
+
+type forwardingJSON struct {
+	HostHeader   string   `json:"host_header"`
+	XForwarded   string   `json:"x_forwarded"`
+	Forwarded    string   `json:"forwarded"`
+	XRealIP      *bool    `json:"x_real_ip"`
+	StripHeaders []string `json:"strip_headers"`
+}
+
+var defaultForwarding = ForwardingConfig{
+	HostHeader: "preserve",
+	XForwarded: "append",
+	Forwarded:  "off",
+	XRealIP:    true,
+}
+
+// toConfig fills the fields missing from the JSON object with defaults, so a
+// route only has to spell out what differs from the top-level forwarding.
+func (f *forwardingJSON) toConfig(defaults ForwardingConfig) ForwardingConfig {
+	if f == nil {
+		return defaults
+	}
+
+	forwarding := defaults
+	if f.HostHeader != "" {
+		forwarding.HostHeader = f.HostHeader
+	}
+	if f.XForwarded != "" {
+		forwarding.XForwarded = f.XForwarded
+	}
+	if f.Forwarded != "" {
+		forwarding.Forwarded = f.Forwarded
+	}
+	if f.XRealIP != nil {
+		forwarding.XRealIP = *f.XRealIP
+	}
+	if f.StripHeaders != nil {
+		forwarding.StripHeaders = f.StripHeaders
+	}
+	return forwarding
+}
+
+func (f *ForwardingConfig) Validate() error {
+	var errs fieldErrors
+
+	if f.HostHeader == "" {
+		errs.add("host_header", "cannot be empty")
+	}
+
+	if f.XForwarded != "append" && f.XForwarded != "overwrite" && f.XForwarded != "off" {
+		errs.add("x_forwarded", "must be 'append', 'overwrite' or 'off'")
+	}
+
+	if f.Forwarded != "append" && f.Forwarded != "overwrite" && f.Forwarded != "off" {
+		errs.add("forwarded", "must be 'append', 'overwrite' or 'off'")
+	}
+
+	return errs.err()
+}
+
+type StatusRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+type HealthCheckConfig struct {
+	Path               string            `json:"path"`
+	Method             string            `json:"method"`
+	ExpectedStatuses   []StatusRange     `json:"expected_statuses"`
+	ExpectedBody       string            `json:"expected_body"`
+	ExpectedBodyRegex  string            `json:"expected_body_regex"`
+	Headers            map[string]string `json:"headers"`
+	Host               string            `json:"host"`
+	HealthyThreshold   int               `json:"healthy_threshold"`
+	UnhealthyThreshold int               `json:"unhealthy_threshold"`
+	Jitter             time.Duration     `json:"jitter"`
+	// Timeout bounds one probe and must be shorter than the check interval.
+	Timeout time.Duration `json:"timeout"`
+}
+
+type healthCheckJSON struct {
+	Path               string            `json:"path"`
+	Method             string            `json:"method"`
+	ExpectedStatuses   []string          `json:"expected_statuses"`
+	ExpectedBody       string            `json:"expected_body"`
+	ExpectedBodyRegex  string            `json:"expected_body_regex"`
+	Headers            map[string]string `json:"headers"`
+	Host               string            `json:"host"`
+	HealthyThreshold   int               `json:"healthy_threshold"`
+	UnhealthyThreshold int               `json:"unhealthy_threshold"`
+	Jitter             string            `json:"jitter"`
+	Timeout            string            `json:"timeout"`
+}
+
+var defaultHealthCheck = HealthCheckConfig{
+	Path:               "/health",
+	Method:             "GET",
+	ExpectedStatuses:   []StatusRange{{Min: 100, Max: 499}},
+	HealthyThreshold:   1,
+	UnhealthyThreshold: 1,
+}
+
+// toConfig works like forwardingJSON.toConfig: unset fields keep the defaults.
+func (h *healthCheckJSON) toConfig(defaults HealthCheckConfig) (HealthCheckConfig, error) {
+	if h == nil {
+		return defaults, nil
+	}
+
+	check := defaults
+	if h.Path != "" {
+		check.Path = h.Path
+	}
+	if h.Method != "" {
+		check.Method = strings.ToUpper(h.Method)
+	}
+	var errs fieldErrors
+	if h.ExpectedStatuses != nil {
+		check.ExpectedStatuses = nil
+		for i, value := range h.ExpectedStatuses {
+			statusRange, err := parseStatusRange(value)
+			if err != nil {
+				errs.add(joinPath("expected_statuses", strconv.Itoa(i)), "%v", err)
+				continue
+			}
+			check.ExpectedStatuses = append(check.ExpectedStatuses, statusRange)
+		}
+	}
+	if h.ExpectedBody != "" {
+		check.ExpectedBody = h.ExpectedBody
+	}
+	if h.ExpectedBodyRegex != "" {
+		check.ExpectedBodyRegex = h.ExpectedBodyRegex
+	}
+	if h.Headers != nil {
+		check.Headers = h.Headers
+	}
+	if h.Host != "" {
+		check.Host = h.Host
+	}
+	if h.HealthyThreshold != 0 {
+		check.HealthyThreshold = h.HealthyThreshold
+	}
+	if h.UnhealthyThreshold != 0 {
+		check.UnhealthyThreshold = h.UnhealthyThreshold
+	}
+	if h.Jitter != "" {
+		jitter, err := time.ParseDuration(h.Jitter)
+		if err != nil {
+			errs.add("jitter", "invalid duration %q", h.Jitter)
+		}
+		check.Jitter = jitter
+	}
+	if h.Timeout != "" {
+		timeout, err := time.ParseDuration(h.Timeout)
+		if err != nil {
+			errs.add("timeout", "invalid duration %q", h.Timeout)
+		}
+		check.Timeout = timeout
+	}
+	return check, errs.err()
+}
+
+// parseStatusRange accepts "200", "200-299" or "2xx".
+func parseStatusRange(value string) (StatusRange, error) {
+	invalid := fmt.Errorf("invalid status %q: use \"200\", \"200-299\" or \"2xx\"", value)
+
+	if len(value) == 3 && strings.HasSuffix(strings.ToLower(value), "xx") {
+		class, err := strconv.Atoi(value[:1])
+		if err != nil {
+			return StatusRange{}, invalid
+		}
+		return StatusRange{Min: class * 100, Max: class*100 + 99}, nil
+	}
+
+	low, high, isRange := strings.Cut(value, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return StatusRange{}, invalid
+	}
+	max := min
+	if isRange {
+		max, err = strconv.Atoi(strings.TrimSpace(high))
+		if err != nil {
+			return StatusRange{}, invalid
+		}
+	}
+	return StatusRange{Min: min, Max: max}, nil
+}
+
+func (h *HealthCheckConfig) Validate() error {
+	var errs fieldErrors
+
+	if !strings.HasPrefix(h.Path, "/") {
+		errs.add("path", "must start with '/'")
+	}
+
+	for i, statusRange := range h.ExpectedStatuses {
+		if statusRange.Min < 100 || statusRange.Max > 599 || statusRange.Min > statusRange.Max {
+			errs.add(joinPath("expected_statuses", strconv.Itoa(i)), "invalid status range %d-%d", statusRange.Min, statusRange.Max)
+		}
+	}
+
+	if h.ExpectedBodyRegex != "" {
+		if _, err := regexp.Compile(h.ExpectedBodyRegex); err != nil {
+			errs.add("expected_body_regex", "%v", err)
+		}
+	}
+
+	if h.HealthyThreshold < 1 {
+		errs.add("healthy_threshold", "must be at least 1")
+	}
+	if h.UnhealthyThreshold < 1 {
+		errs.add("unhealthy_threshold", "must be at least 1")
+	}
+
+	if h.Jitter < 0 {
+		errs.add("jitter", "cannot be negative")
+	}
+
+	if h.Timeout <= 0 {
+		errs.add("timeout", "must be positive")
+	}
+
+	return errs.err()
+}
+
+// validateInterval checks the settings of a health check that depend on the
+// interval between checks.
+func (h *HealthCheckConfig) validateInterval(interval time.Duration) error {
+	var errs fieldErrors
+	if interval > 0 && h.Timeout >= interval {
+		// A probe must finish before the next one starts.
+		errs.add("timeout", "must be shorter than health_check_frequency (%v)", interval)
+	} else if interval > 0 && h.Jitter+h.Timeout >= interval {
+		// The jitter delays the probe, so both must fit in one interval.
+		errs.add("jitter", "plus timeout (%v) must be shorter than health_check_frequency (%v)", h.Timeout, interval)
+	}
+	return errs.err()
+}
+
+type TransportConfig struct {
+	MaxIdleConns          int           `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host"`
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout"`
+	DialTimeout           time.Duration `json:"dial_timeout"`
+	KeepAlive             time.Duration `json:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout"`
+	DisableHTTP2          bool          `json:"disable_http2"`
+}
+
+func (t *TransportConfig) Validate() error {
+	var errs fieldErrors
+
+	if t.MaxIdleConns < 0 {
+		errs.add("max_idle_conns", "cannot be negative")
+	}
+	if t.MaxIdleConnsPerHost < 0 {
+		errs.add("max_idle_conns_per_host", "cannot be negative")
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"idle_conn_timeout", t.IdleConnTimeout},
+		{"dial_timeout", t.DialTimeout},
+		{"keep_alive", t.KeepAlive},
+		{"tls_handshake_timeout", t.TLSHandshakeTimeout},
+		{"response_header_timeout", t.ResponseHeaderTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			errs.add(timeout.name, "cannot be negative")
+		}
+	}
+
+	return errs.err()
+}
+
+type OutlierDetectionConfig struct {
+	Enabled            bool          `json:"enabled"`
+	ConsecutiveErrors  int           `json:"consecutive_errors"`
+	ErrorRate          float64       `json:"error_rate"`
+	MinRequests        int           `json:"min_requests"`
+	Window             time.Duration `json:"window"`
+	BaseEjectionTime   time.Duration `json:"base_ejection_time"`
+	MaxEjectionTime    time.Duration `json:"max_ejection_time"`
+	MaxEjectionPercent int           `json:"max_ejection_percent"`
+}
+
+func (o *OutlierDetectionConfig) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+
+	var errs fieldErrors
+
+	if o.ConsecutiveErrors < 0 {
+		errs.add("consecutive_errors", "cannot be negative")
+	}
+	if o.MinRequests < 0 {
+		errs.add("min_requests", "cannot be negative")
+	}
+
+	if o.ErrorRate < 0 || o.ErrorRate > 1 {
+		errs.add("error_rate", "must be between 0 and 1")
+	}
+
+	// The window is split into buckets, so it needs some resolution.
+	if o.Window < time.Second {
+		errs.add("window", "must be at least 1s")
+	}
+	if o.BaseEjectionTime <= 0 {
+		errs.add("base_ejection_time", "must be positive")
+	}
+
+	if o.MaxEjectionTime < o.BaseEjectionTime {
+		errs.add("max_ejection_time", "must not be shorter than base_ejection_time")
+	}
+
+	if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+		errs.add("max_ejection_percent", "must be between 0 and 100")
+	}
+
+	return errs.err()
+}
+
+type SlowStartConfig struct {
+	Window           time.Duration `json:"window"`
+	Aggression       float64       `json:"aggression"`
+	MinWeightPercent int           `json:"min_weight_percent"`
+}
+
+func (s *SlowStartConfig) Validate() error {
+	var errs fieldErrors
+
+	if s.Window < 0 {
+		errs.add("window", "cannot be negative")
+	}
+
+	if s.Aggression <= 0 {
+		errs.add("aggression", "must be positive")
+	}
+
+	if s.MinWeightPercent < 1 || s.MinWeightPercent > 100 {
+		errs.add("min_weight_percent", "must be between 1 and 100")
+	}
+
+	return errs.err()
+}
+
+type AdminTokenConfig struct {
+	Name        string `json:"name"`
+	TokenSHA256 string `json:"token_sha256"`
+	Role        string `json:"role"`
+}
+
+type AdminUserConfig struct {
+	Username string `json:"username"`
+	// PasswordHash is a bcrypt or argon2id (PHC string) hash.
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+type AdminClientCertConfig struct {
+	CommonName string `json:"common_name"`
+	Role       string `json:"role"`
+}
+
+type AdminTLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+// AdminConfig secures the admin API. Tokens are stored as hex-encoded
+// SHA-256 digests and passwords as bcrypt or argon2id hashes, never in clear
+// text.
+type AdminConfig struct {
+	BindAddress string                  `json:"bind_address"`
+	Tokens      []AdminTokenConfig      `json:"tokens"`
+	Users       []AdminUserConfig       `json:"users"`
+	ClientCerts []AdminClientCertConfig `json:"client_certs"`
+	TLS         AdminTLSConfig          `json:"tls"`
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+func validAdminRole(role string) bool {
+	return role == "read-only" || role == "read-write"
+}
+
+func (a *AdminConfig) Validate() error {
+	var errs fieldErrors
+
+	if a.BindAddress != "" && net.ParseIP(a.BindAddress) == nil && a.BindAddress != "localhost" {
+		errs.add("bind_address", "must be an IP address or 'localhost'")
+	}
+
+	names := make(map[string]bool)
+	for i, token := range a.Tokens {
+		path := joinPath("tokens", strconv.Itoa(i))
+		if token.Name == "" {
+			errs.add(joinPath(path, "name"), "is required")
+		} else if names[token.Name] {
+			errs.add(joinPath(path, "name"), "duplicate token name %q", token.Name)
+		}
+		names[token.Name] = true
+		if !sha256Pattern.MatchString(token.TokenSHA256) {
+			errs.add(joinPath(path, "token_sha256"), "must be a hex-encoded SHA-256 digest")
+		}
+		if !validAdminRole(token.Role) {
+			errs.add(joinPath(path, "role"), "must be 'read-only' or 'read-write'")
+		}
+	}
+
+	usernames := make(map[string]bool)
+	for i, user := range a.Users {
+		path := joinPath("users", strconv.Itoa(i))
+		if user.Username == "" {
+			errs.add(joinPath(path, "username"), "is required")
+		} else if usernames[user.Username] {
+			errs.add(joinPath(path, "username"), "duplicate username %q", user.Username)
+		}
+		usernames[user.Username] = true
+		// The admin API checks the hash the same way when it verifies a
+		// password, so a hash that passes here can be used.
+		if err := admin.CheckPasswordHash(user.PasswordHash); err != nil {
+			errs.add(joinPath(path, "password_hash"), "%v", err)
+		}
+		if !validAdminRole(user.Role) {
+			errs.add(joinPath(path, "role"), "must be 'read-only' or 'read-write'")
+		}
+	}
+
+	if (a.TLS.CertFile == "") != (a.TLS.KeyFile == "") {
+		errs.add("tls", "requires both cert_file and key_file")
+	}
+	if a.TLS.ClientCAFile != "" && a.TLS.CertFile == "" {
+		errs.add("tls.client_ca_file", "requires cert_file and key_file")
+	}
+	if len(a.ClientCerts) > 0 && a.TLS.ClientCAFile == "" {
+		errs.add("client_certs", "require tls.client_ca_file")
+	}
+	for i, cert := range a.ClientCerts {
+		path := joinPath("client_certs", strconv.Itoa(i))
+		if cert.CommonName == "" {
+			errs.add(joinPath(path, "common_name"), "is required")
+		}
+		if !validAdminRole(cert.Role) {
+			errs.add(joinPath(path, "role"), "must be 'read-only' or 'read-write'")
+		}
+	}
+
+	return errs.err()
+}
+
+type RouteConfig struct {
+	Name                 string            `json:"name"`
+	Host                 string            `json:"host"`
+	PathPrefix           string            `json:"path_prefix"`
+	PathRegex            string            `json:"path_regex"`
+	Methods              []string          `json:"methods"`
+	Headers              map[string]string `json:"headers"`
+	Strategy             string            `json:"strategy"`
+	Timeout              time.Duration     `json:"timeout"`
+	Backends             []BackendConfig   `json:"backends"`
+	EnableStickySessions bool              `json:"enable_sticky_sessions"`
+	HashKey              string            `json:"hash_key"`
+	HashVirtualNodes     int               `json:"hash_virtual_nodes"`
+	HashLoadFactor       float64           `json:"hash_load_factor"`
+	Forwarding           ForwardingConfig  `json:"forwarding"`
+	HealthCheck          HealthCheckConfig `json:"health_check"`
+	Retry                RetryConfig       `json:"retry"`
+}
+
 type ProxyConfig struct {
 	Port                 int             `json:"port"`
 	Admin_port           int             `json:"admin_port"`
@@ -31,57 +520,164 @@ type ProxyConfig struct {
 	BackendsConfig       []BackendConfig `json:"backends"`
 	EnableStickySessions bool            `json:"enable_sticky_sessions"`
 	StickySessionTTL     time.Duration   `json:"sticky_session_ttl"`
+	StickySessionMode    string          `json:"sticky_session_mode"`
+	StickyCookieName     string          `json:"sticky_cookie_name"`
+	StickyCookieSecret   string          `json:"sticky_cookie_secret"`
+	StickyAppCookie      string          `json:"sticky_app_cookie"`
 	SSL                  SSLConfig       `json:"ssl"`
+	Routes               []RouteConfig   `json:"routes"`
+	HashKey              string          `json:"hash_key"`
+	HashVirtualNodes     int             `json:"hash_virtual_nodes"`
+	HashLoadFactor       float64         `json:"hash_load_factor"`
+	TrustedProxies       []string        `json:"trusted_proxies"`
+	// ClientIPHeader is the forwarding header the trusted proxies write,
+	// "x-forwarded-for" or "forwarded". The other one is ignored.
+	ClientIPHeader   string                 `json:"client_ip_header"`
+	ProxyProtocol    bool                   `json:"proxy_protocol"`
+	Forwarding       ForwardingConfig       `json:"forwarding"`
+	Transport        TransportConfig        `json:"transport"`
+	OutlierDetection OutlierDetectionConfig `json:"outlier_detection"`
+	CircuitBreaker   CircuitBreakerConfig   `json:"circuit_breaker"`
+	HealthCheck      HealthCheckConfig      `json:"health_check"`
+	SlowStart        SlowStartConfig        `json:"slow_start"`
+	Admin            AdminConfig            `json:"admin"`
+	AccessLog        AccessLogConfig        `json:"access_log"`
+	Tracing          TracingConfig          `json:"tracing"`
+	RequestID        RequestIDConfig        `json:"request_id"`
+	Retry            RetryConfig            `json:"retry"`
+	RateLimit        RateLimitConfig        `json:"rate_limit"`
+}
+
+// proxyConfigJSON is the file format of ProxyConfig: durations are strings
+// and optional settings are pointers so defaults can be told apart.
+type proxyConfigJSON struct {
+	Port                 int             `json:"port"`
+	Admin_port           int             `json:"admin_port"`
+	Strategy             string          `json:"strategy"`
+	HealthCheckFreq      string          `json:"health_check_frequency"`
+	HealthCheckMethod    string          `json:"health_check_method"`
+	Backend_timeout      string          `json:"backend_timeout"`
+	Backends             []BackendConfig `json:"backends"`
+	EnableStickySessions bool            `json:"enable_sticky_sessions"`
+	StickySessionTTL     string          `json:"sticky_session_ttl"`
+	StickySessionMode    string          `json:"sticky_session_mode"`
+	StickyCookieName     string          `json:"sticky_cookie_name"`
+	StickyCookieSecret   string          `json:"sticky_cookie_secret"`
+	StickyAppCookie      string          `json:"sticky_app_cookie"`
+	SSL                  struct {
+		Enabled  bool   `json:"enabled"`
+		CertFile string `json:"cert_file"`
+		KeyFile  string `json:"key_file"`
+	} `json:"ssl"`
+	Routes []struct {
+		Name                 string            `json:"name"`
+		Host                 string            `json:"host"`
+		PathPrefix           string            `json:"path_prefix"`
+		PathRegex            string            `json:"path_regex"`
+		Methods              []string          `json:"methods"`
+		Headers              map[string]string `json:"headers"`
+		Strategy             string            `json:"strategy"`
+		Timeout              string            `json:"timeout"`
+		Backends             []BackendConfig   `json:"backends"`
+		EnableStickySessions bool              `json:"enable_sticky_sessions"`
+		HashKey              string            `json:"hash_key"`
+		HashVirtualNodes     int               `json:"hash_virtual_nodes"`
+		HashLoadFactor       float64           `json:"hash_load_factor"`
+		Forwarding           *forwardingJSON   `json:"forwarding"`
+		HealthCheck          *healthCheckJSON  `json:"health_check"`
+		Retry                *retryJSON        `json:"retry"`
+	} `json:"routes"`
+	HashKey          string          `json:"hash_key"`
+	HashVirtualNodes int             `json:"hash_virtual_nodes"`
+	HashLoadFactor   float64         `json:"hash_load_factor"`
+	TrustedProxies   []string        `json:"trusted_proxies"`
+	ClientIPHeader   string          `json:"client_ip_header"`
+	ProxyProtocol    bool            `json:"proxy_protocol"`
+	Forwarding       *forwardingJSON `json:"forwarding"`
+	Transport        struct {
+		MaxIdleConns          int    `json:"max_idle_conns"`
+		MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host"`
+		IdleConnTimeout       string `json:"idle_conn_timeout"`
+		DialTimeout           string `json:"dial_timeout"`
+		KeepAlive             string `json:"keep_alive"`
+		TLSHandshakeTimeout   string `json:"tls_handshake_timeout"`
+		ResponseHeaderTimeout string `json:"response_header_timeout"`
+		DisableHTTP2          bool   `json:"disable_http2"`
+	} `json:"transport"`
+	OutlierDetection struct {
+		Enabled            bool     `json:"enabled"`
+		ConsecutiveErrors  *int     `json:"consecutive_errors"`
+		ErrorRate          *float64 `json:"error_rate"`
+		MinRequests        *int     `json:"min_requests"`
+		Window             string   `json:"window"`
+		BaseEjectionTime   string   `json:"base_ejection_time"`
+		MaxEjectionTime    string   `json:"max_ejection_time"`
+		MaxEjectionPercent *int     `json:"max_ejection_percent"`
+	} `json:"outlier_detection"`
+	CircuitBreaker circuitBreakerJSON `json:"circuit_breaker"`
+	HealthCheck    *healthCheckJSON   `json:"health_check"`
+	SlowStart      struct {
+		Window           string   `json:"window"`
+		Aggression       *float64 `json:"aggression"`
+		MinWeightPercent *int     `json:"min_weight_percent"`
+	} `json:"slow_start"`
+	Admin     AdminConfig   `json:"admin"`
+	AccessLog accessLogJSON `json:"access_log"`
+	Tracing   tracingJSON   `json:"tracing"`
+	RequestID requestIDJSON `json:"request_id"`
+	Retry     *retryJSON    `json:"retry"`
+	RateLimit rateLimitJSON `json:"rate_limit"`
 }
 
-func LoadConfiguration() (p ProxyConfig, err error) {
-	configuration := struct {
-		Port                 int             `json:"port"`
-		Admin_port           int             `json:"admin_port"`
-		Strategy             string          `json:"strategy"`
-		HealthCheckFreq      string          `json:"health_check_frequency"`
-		HealthCheckMethod    string          `json:"health_check_method"`
-		Backend_timeout      string          `json:"backend_timeout"`
-		Backends             []BackendConfig `json:"backends"`
-		EnableStickySessions bool            `json:"enable_sticky_sessions"`
-		StickySessionTTL     string          `json:"sticky_session_ttl"`
-		SSL                  struct {
-			Enabled  bool   `json:"enabled"`
-			CertFile string `json:"cert_file"`
-			KeyFile  string `json:"key_file"`
-		} `json:"ssl"`
-	}{}
-
-	jsonFile, err := os.Open("config.json")
+// LoadConfiguration reads the configuration at path in the format given by
+// its extension (.json, .yaml, .yml or .toml), applies PROXY_* environment
+// overrides and validates the result.
+func LoadConfiguration(path string) (p ProxyConfig, err error) {
+	var configuration proxyConfigJSON
+
+	src, err := readConfigSource(path)
 	if err != nil {
-		return ProxyConfig{}, errors.New("Error while opening the configuration file")
+		return ProxyConfig{}, err
+	}
+	if !src.converted {
+		src.lines = jsonLines(src.data)
 	}
-	defer jsonFile.Close()
 
-	byteValue, _ := io.ReadAll(jsonFile)
-	json.Unmarshal(byteValue, &configuration)
+	var errs fieldErrors
+	errs.nest("", unknownFields(src.data))
 
-	p.Port = configuration.Port
-	p.Admin_port = configuration.Admin_port
-	p.Strategy = configuration.Strategy
+	if err := json.Unmarshal(src.data, &configuration); err != nil {
+		return ProxyConfig{}, errors.Join(src.locate(errs.err()), src.decodeError(err))
+	}
 
-	p.Backend_timeout, err = time.ParseDuration(configuration.Backend_timeout)
+	src.env, err = applyEnvOverrides(&configuration, os.Environ())
 	if err != nil {
-		return ProxyConfig{}, errors.New("Error parsing timeout duration")
+		return ProxyConfig{}, err
 	}
 
-	p.HealthCheckFreq, err = time.ParseDuration(configuration.HealthCheckFreq)
-	if err != nil {
-		return ProxyConfig{}, errors.New("Error parsing health check frequency ")
+	// parseDuration records an invalid duration and leaves dest unchanged;
+	// an empty value keeps the default.
+	parseDuration := func(path, value string, dest *time.Duration) {
+		if value == "" {
+			return
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs.add(path, "invalid duration %q", value)
+			return
+		}
+		*dest = d
 	}
 
-	p.BackendsConfig = configuration.Backends
+	p.Port = configuration.Port
+	p.Admin_port = configuration.Admin_port
+	p.Strategy = configuration.Strategy
+
+	parseDuration("backend_timeout", configuration.Backend_timeout, &p.Backend_timeout)
+	parseDuration("health_check_frequency", configuration.HealthCheckFreq, &p.HealthCheckFreq)
 
+	p.BackendsConfig = configuration.Backends
 	for i := range p.BackendsConfig {
-		_, err := url.Parse(p.BackendsConfig[i].URL)
-		if err != nil {
-			return ProxyConfig{}, errors.New("Error parsing backend url: " + p.BackendsConfig[i].URL)
-		}
 		if p.BackendsConfig[i].Weight == 0 {
 			p.BackendsConfig[i].Weight = 1
 		}
@@ -90,71 +686,377 @@ func LoadConfiguration() (p ProxyConfig, err error) {
 	p.HealthCheckMethod = configuration.HealthCheckMethod
 	p.EnableStickySessions = configuration.EnableStickySessions
 
-	p.StickySessionTTL, err = time.ParseDuration(configuration.StickySessionTTL)
-	if err != nil {
-		return ProxyConfig{}, errors.New("error parsing sticky_session_ttl")
+	parseDuration("sticky_session_ttl", configuration.StickySessionTTL, &p.StickySessionTTL)
+
+	p.StickySessionMode = configuration.StickySessionMode
+	if p.StickySessionMode == "" {
+		p.StickySessionMode = "ip"
 	}
+	p.StickyCookieName = configuration.StickyCookieName
+	p.StickyCookieSecret = configuration.StickyCookieSecret
+	p.StickyAppCookie = configuration.StickyAppCookie
 
 	p.SSL.Enabled = configuration.SSL.Enabled
 	p.SSL.CertFile = configuration.SSL.CertFile
 	p.SSL.KeyFile = configuration.SSL.KeyFile
 
-	err = p.Validate()
-	if err != nil {
-		return ProxyConfig{}, err
+	p.HashKey = configuration.HashKey
+	if p.HashKey == "" {
+		p.HashKey = "client-ip"
+	}
+	p.HashVirtualNodes = configuration.HashVirtualNodes
+	p.HashLoadFactor = configuration.HashLoadFactor
+	p.TrustedProxies = configuration.TrustedProxies
+	p.ClientIPHeader = configuration.ClientIPHeader
+	if p.ClientIPHeader == "" {
+		p.ClientIPHeader = "x-forwarded-for"
+	}
+	p.ProxyProtocol = configuration.ProxyProtocol
+	p.Forwarding = configuration.Forwarding.toConfig(defaultForwarding)
+
+	p.Transport.MaxIdleConns = configuration.Transport.MaxIdleConns
+	p.Transport.MaxIdleConnsPerHost = configuration.Transport.MaxIdleConnsPerHost
+	p.Transport.DisableHTTP2 = configuration.Transport.DisableHTTP2
+	transportDurations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"idle_conn_timeout", configuration.Transport.IdleConnTimeout, &p.Transport.IdleConnTimeout},
+		{"dial_timeout", configuration.Transport.DialTimeout, &p.Transport.DialTimeout},
+		{"keep_alive", configuration.Transport.KeepAlive, &p.Transport.KeepAlive},
+		{"tls_handshake_timeout", configuration.Transport.TLSHandshakeTimeout, &p.Transport.TLSHandshakeTimeout},
+		{"response_header_timeout", configuration.Transport.ResponseHeaderTimeout, &p.Transport.ResponseHeaderTimeout},
+	}
+	for _, d := range transportDurations {
+		parseDuration("transport."+d.name, d.value, d.dest)
+	}
+
+	p.HealthCheck, err = configuration.HealthCheck.toConfig(defaultHealthCheck)
+	errs.nest("health_check", err)
+	if p.HealthCheck.Timeout == 0 {
+		// Configurations from before health_check.timeout probed with
+		// backend_timeout, which had to be shorter than the interval.
+		p.HealthCheck.Timeout = p.Backend_timeout
+		if p.HealthCheckFreq > 0 && p.HealthCheck.Timeout >= p.HealthCheckFreq {
+			p.HealthCheck.Timeout = p.HealthCheckFreq / 2
+		}
+	}
+
+	p.Admin = configuration.Admin
+
+	p.AccessLog, err = configuration.AccessLog.toConfig()
+	errs.nest("access_log", err)
+
+	p.Tracing, err = configuration.Tracing.toConfig()
+	errs.nest("tracing", err)
+
+	p.RequestID = configuration.RequestID.toConfig()
+
+	p.Retry, err = configuration.Retry.toConfig(defaultRetry)
+	errs.nest("retry", err)
+
+	p.SlowStart = SlowStartConfig{Aggression: 1, MinWeightPercent: 10}
+	parseDuration("slow_start.window", configuration.SlowStart.Window, &p.SlowStart.Window)
+	if configuration.SlowStart.Aggression != nil {
+		p.SlowStart.Aggression = *configuration.SlowStart.Aggression
+	}
+	if configuration.SlowStart.MinWeightPercent != nil {
+		p.SlowStart.MinWeightPercent = *configuration.SlowStart.MinWeightPercent
+	}
+
+	outlier := configuration.OutlierDetection
+	p.OutlierDetection = OutlierDetectionConfig{
+		Enabled:            outlier.Enabled,
+		ConsecutiveErrors:  5,
+		ErrorRate:          0.5,
+		MinRequests:        10,
+		Window:             30 * time.Second,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionTime:    5 * time.Minute,
+		MaxEjectionPercent: 50,
+	}
+	if outlier.ConsecutiveErrors != nil {
+		p.OutlierDetection.ConsecutiveErrors = *outlier.ConsecutiveErrors
+	}
+	if outlier.ErrorRate != nil {
+		p.OutlierDetection.ErrorRate = *outlier.ErrorRate
+	}
+	if outlier.MinRequests != nil {
+		p.OutlierDetection.MinRequests = *outlier.MinRequests
+	}
+	if outlier.MaxEjectionPercent != nil {
+		p.OutlierDetection.MaxEjectionPercent = *outlier.MaxEjectionPercent
+	}
+	outlierDurations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"window", outlier.Window, &p.OutlierDetection.Window},
+		{"base_ejection_time", outlier.BaseEjectionTime, &p.OutlierDetection.BaseEjectionTime},
+		{"max_ejection_time", outlier.MaxEjectionTime, &p.OutlierDetection.MaxEjectionTime},
+	}
+	for _, d := range outlierDurations {
+		parseDuration("outlier_detection."+d.name, d.value, d.dest)
+	}
+
+	p.CircuitBreaker, err = configuration.CircuitBreaker.toConfig()
+	errs.nest("circuit_breaker", err)
+
+	p.RateLimit, err = configuration.RateLimit.toConfig()
+	errs.nest("rate_limit", err)
+
+	for i, r := range configuration.Routes {
+		path := joinPath("routes", strconv.Itoa(i))
+		route := RouteConfig{
+			Name:                 r.Name,
+			Host:                 r.Host,
+			PathPrefix:           r.PathPrefix,
+			PathRegex:            r.PathRegex,
+			Methods:              r.Methods,
+			Headers:              r.Headers,
+			Strategy:             r.Strategy,
+			Timeout:              p.Backend_timeout,
+			Backends:             r.Backends,
+			EnableStickySessions: r.EnableStickySessions,
+			HashKey:              r.HashKey,
+			HashVirtualNodes:     r.HashVirtualNodes,
+			HashLoadFactor:       r.HashLoadFactor,
+			Forwarding:           r.Forwarding.toConfig(p.Forwarding),
+		}
+		route.HealthCheck, err = r.HealthCheck.toConfig(p.HealthCheck)
+		errs.nest(joinPath(path, "health_check"), err)
+		route.Retry, err = r.Retry.toConfig(p.Retry)
+		errs.nest(joinPath(path, "retry"), err)
+		if route.Strategy == "" {
+			route.Strategy = p.Strategy
+		}
+		if route.HashKey == "" {
+			route.HashKey = p.HashKey
+		}
+		if route.HashVirtualNodes == 0 {
+			route.HashVirtualNodes = p.HashVirtualNodes
+		}
+		if route.HashLoadFactor == 0 {
+			route.HashLoadFactor = p.HashLoadFactor
+		}
+		parseDuration(joinPath(path, "timeout"), r.Timeout, &route.Timeout)
+		for i := range route.Backends {
+			if route.Backends[i].Weight == 0 {
+				route.Backends[i].Weight = 1
+			}
+		}
+		p.Routes = append(p.Routes, route)
+	}
+
+	errs.nest("", p.Validate())
+	if len(errs) > 0 {
+		return ProxyConfig{}, src.locate(errs.err())
 	}
 
 	return p, nil
 }
 
+// Validate checks the whole configuration and reports every problem at
+// once, each as a *FieldError joined with errors.Join.
 func (p *ProxyConfig) Validate() error {
+	var errs fieldErrors
+
 	if p.Port <= 0 || p.Port > 65535 {
-		return errors.New("invalid port: must be between 1-65535")
+		errs.add("port", "must be between 1-65535")
 	}
 
 	if p.Admin_port <= 0 || p.Admin_port > 65535 {
-		return errors.New("invalid admin_port: must be between 1-65535")
+		errs.add("admin_port", "must be between 1-65535")
+	} else if p.Port == p.Admin_port {
+		errs.add("admin_port", "cannot be the same as port")
 	}
 
-	if p.Port == p.Admin_port {
-		return errors.New("port and admin_port cannot be the same")
+	if !validStrategy(p.Strategy) {
+		errs.add("strategy", "must be 'round-robin', 'least-conn', 'consistent-hash' or 'p2c-ewma', got %q", p.Strategy)
 	}
 
-	if p.Strategy != "round-robin" && p.Strategy != "least-conn" {
-		fmt.Println(p.Strategy)
-		return errors.New("invalid strategy: must be 'round-robin' or 'least-conn'")
-	}
+	errs.nest("", validateHashOptions(p.HashKey, p.HashVirtualNodes, p.HashLoadFactor))
 
 	if p.HealthCheckMethod != "tcp" && p.HealthCheckMethod != "http" {
-		return errors.New("invalid health_check_method: must be 'tcp' or 'http'")
+		errs.add("health_check_method", "must be 'tcp' or 'http', got %q", p.HealthCheckMethod)
 	}
 
 	if p.HealthCheckFreq <= 0 {
-		return errors.New("health_check_frequency must be positive")
+		errs.add("health_check_frequency", "must be positive")
 	}
 
 	if p.Backend_timeout <= 0 {
-		return errors.New("backend_timeout must be positive")
+		errs.add("backend_timeout", "must be positive")
+	}
+
+	if p.StickySessionTTL < 0 {
+		errs.add("sticky_session_ttl", "cannot be negative")
+	}
+
+	errs.nest("forwarding", p.Forwarding.Validate())
+	errs.nest("transport", p.Transport.Validate())
+	errs.nest("outlier_detection", p.OutlierDetection.Validate())
+	errs.nest("circuit_breaker", p.CircuitBreaker.Validate())
+	errs.nest("health_check", p.HealthCheck.Validate())
+	errs.nest("health_check", p.HealthCheck.validateInterval(p.HealthCheckFreq))
+	errs.nest("slow_start", p.SlowStart.Validate())
+	errs.nest("admin", p.Admin.Validate())
+	errs.nest("access_log", p.AccessLog.Validate())
+	errs.nest("tracing", p.Tracing.Validate())
+	errs.nest("request_id", p.RequestID.Validate())
+	errs.nest("retry", p.Retry.Validate())
+	errs.nest("rate_limit", p.RateLimit.Validate())
+
+	if len(p.BackendsConfig) == 0 && len(p.Routes) == 0 {
+		errs.add("backends", "at least one backend must be configured, here or in routes")
+	}
+	errs.nest("backends", validateBackends(p.BackendsConfig))
+
+	routeNames := make(map[string]int)
+	for i, route := range p.Routes {
+		path := joinPath("routes", strconv.Itoa(i))
+		errs.nest(path, route.Validate())
+		errs.nest(joinPath(path, "health_check"), route.HealthCheck.validateInterval(p.HealthCheckFreq))
+		if first, ok := routeNames[route.Name]; ok && route.Name != "" {
+			errs.add(joinPath(path, "name"), "duplicate of route %d (%s)", first, route.Name)
+		} else {
+			routeNames[route.Name] = i
+		}
+	}
+
+	if p.AccessLog.Enabled {
+		sampledRoutes := make([]string, 0, len(p.AccessLog.RouteSampleRates))
+		for route := range p.AccessLog.RouteSampleRates {
+			sampledRoutes = append(sampledRoutes, route)
+		}
+		sort.Strings(sampledRoutes)
+		for _, route := range sampledRoutes {
+			if _, ok := routeNames[route]; !ok {
+				errs.add(joinPath("access_log.route_sample_rates", route), "no route named %q", route)
+			}
+		}
+	}
+
+	for i, rule := range p.RateLimit.Rules {
+		for j, route := range rule.Routes {
+			if _, ok := routeNames[route]; !ok && route != "" {
+				errs.add(joinPath(joinPath("rate_limit.rules", strconv.Itoa(i)), joinPath("routes", strconv.Itoa(j))), "no route named %q", route)
+			}
+		}
+	}
+
+	for i, entry := range p.TrustedProxies {
+		valid := net.ParseIP(entry) != nil
+		if strings.Contains(entry, "/") {
+			_, _, err := net.ParseCIDR(entry)
+			valid = err == nil
+		}
+		if !valid {
+			errs.add(joinPath("trusted_proxies", strconv.Itoa(i)), "invalid IP address or CIDR %q", entry)
+		}
 	}
 
-	if len(p.BackendsConfig) == 0 {
-		return errors.New("at least one backend must be configured")
+	if p.ClientIPHeader != "x-forwarded-for" && p.ClientIPHeader != "forwarded" {
+		errs.add("client_ip_header", "must be 'x-forwarded-for' or 'forwarded', got %q", p.ClientIPHeader)
+	}
+
+	if p.ProxyProtocol && len(p.TrustedProxies) == 0 {
+		errs.add("proxy_protocol", "requires trusted_proxies to be configured")
+	}
+
+	switch p.StickySessionMode {
+	case "ip", "cookie":
+	case "app-cookie":
+		if p.StickyAppCookie == "" {
+			errs.add("sticky_app_cookie", "must be specified when sticky_session_mode is 'app-cookie'")
+		}
+	default:
+		errs.add("sticky_session_mode", "must be 'ip', 'cookie' or 'app-cookie'")
 	}
 
 	if p.SSL.Enabled {
 		if p.SSL.CertFile == "" {
-			return errors.New("ssl cert_file must be specified when SSL is enabled")
+			errs.add("ssl.cert_file", "must be specified when SSL is enabled")
+		} else if _, err := os.Stat(p.SSL.CertFile); os.IsNotExist(err) {
+			errs.add("ssl.cert_file", "file does not exist: %s", p.SSL.CertFile)
 		}
 		if p.SSL.KeyFile == "" {
-			return errors.New("ssl key_file must be specified when SSL is enabled")
+			errs.add("ssl.key_file", "must be specified when SSL is enabled")
+		} else if _, err := os.Stat(p.SSL.KeyFile); os.IsNotExist(err) {
+			errs.add("ssl.key_file", "file does not exist: %s", p.SSL.KeyFile)
 		}
-		if _, err := os.Stat(p.SSL.CertFile); os.IsNotExist(err) {
-			return errors.New("ssl cert_file does not exist: " + p.SSL.CertFile)
-		}
-		if _, err := os.Stat(p.SSL.KeyFile); os.IsNotExist(err) {
-			return errors.New("ssl key_file does not exist: " + p.SSL.KeyFile)
+	}
+
+	return errs.err()
+}
+
+func (r *RouteConfig) Validate() error {
+	var errs fieldErrors
+
+	if r.Name == "" {
+		errs.add("name", "every route must have a name")
+	}
+
+	if !validStrategy(r.Strategy) {
+		errs.add("strategy", "must be 'round-robin', 'least-conn', 'consistent-hash' or 'p2c-ewma', got %q", r.Strategy)
+	}
+
+	errs.nest("", validateHashOptions(r.HashKey, r.HashVirtualNodes, r.HashLoadFactor))
+
+	if r.Timeout <= 0 {
+		errs.add("timeout", "must be positive")
+	}
+
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		errs.add("path_prefix", "must start with '/'")
+	}
+
+	if r.PathRegex != "" {
+		if _, err := regexp.Compile(r.PathRegex); err != nil {
+			errs.add("path_regex", "%v", err)
 		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	errs.nest("forwarding", r.Forwarding.Validate())
+	errs.nest("health_check", r.HealthCheck.Validate())
+	errs.nest("retry", r.Retry.Validate())
+
+	if len(r.Backends) == 0 {
+		errs.add("backends", "must have at least one backend")
+	}
+	errs.nest("backends", validateBackends(r.Backends))
+
+	return errs.err()
+}
+
+func validStrategy(strategy string) bool {
+	switch strategy {
+	case "round-robin", "least-conn", "consistent-hash", "p2c-ewma":
+		return true
+	}
+	return false
+}
+
+func validateHashOptions(hashKey string, virtualNodes int, loadFactor float64) error {
+	var errs fieldErrors
+
+	switch {
+	case hashKey == "client-ip", hashKey == "path":
+	case strings.HasPrefix(hashKey, "header:") && len(hashKey) > len("header:"):
+	case strings.HasPrefix(hashKey, "cookie:") && len(hashKey) > len("cookie:"):
+	default:
+		errs.add("hash_key", "must be 'client-ip', 'path', 'header:<name>' or 'cookie:<name>'")
+	}
+
+	if virtualNodes < 0 {
+		errs.add("hash_virtual_nodes", "cannot be negative")
+	}
+
+	if loadFactor != 0 && loadFactor < 1 {
+		errs.add("hash_load_factor", "must be 0 (unbounded) or at least 1")
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "PROXY_"
+
+// applyEnvOverrides sets configuration fields from PROXY_* variables. The
+// name is the upper-cased JSON path joined with underscores, e.g. PROXY_PORT,
+// PROXY_SSL_ENABLED or PROXY_OUTLIER_DETECTION_WINDOW. List elements are
+// addressed by index, e.g. PROXY_ROUTES_0_TIMEOUT, and the index one past
+// the end appends an element. Lists and objects such as PROXY_BACKENDS take
+// a JSON value; string lists also accept a comma-separated value.
+//
+// Variables that match no field are only warned about, since other tools
+// use the PROXY_ prefix too. It returns the variable that set each JSON
+// path, so problems with the value can be reported against it.
+func applyEnvOverrides(configuration *proxyConfigJSON, environ []string) (map[string]string, error) {
+	sort.Strings(environ)
+
+	overrides := make(map[string]string)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		path, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || path == "" {
+			continue
+		}
+
+		appended := func(path string) {
+			if _, ok := overrides[path]; !ok {
+				overrides[path] = name
+			}
+		}
+		field, jsonPath, err := lookupEnvField(reflect.ValueOf(configuration).Elem(), strings.ToLower(path), "", appended)
+		if err != nil {
+			log.Printf("Warning: ignoring environment variable %s: %v", name, err)
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return nil, fmt.Errorf("env %s: invalid value %q: %w", name, value, err)
+		}
+		overrides[jsonPath] = name
+	}
+	return overrides, nil
+}
+
+// lookupEnvField resolves a lower-cased variable name against the JSON tags
+// of v, found at JSON path prefix, and returns the field with its JSON path.
+// An exact tag match wins over descending into a nested object, so
+// health_check_method is the top-level field and not health_check.method.
+// appended is called with the path of every list element the name creates.
+func lookupEnvField(v reflect.Value, name, prefix string, appended func(path string)) (reflect.Value, string, error) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), joinPath(prefix, name), nil
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		fieldName := jsonName(t.Field(i))
+		rest, ok := strings.CutPrefix(name, fieldName+"_")
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+			return lookupEnvElement(field, rest, joinPath(prefix, fieldName), appended)
+		}
+		if field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Struct {
+			// A nil object is only set once the name matches a field in
+			// it, so a typo does not change the configuration.
+			target := field
+			if field.IsNil() {
+				target = reflect.New(field.Type().Elem())
+			}
+			nested, path, err := lookupEnvField(target.Elem(), rest, joinPath(prefix, fieldName), appended)
+			if err != nil {
+				continue
+			}
+			if field.IsNil() {
+				field.Set(target)
+			}
+			return nested, path, nil
+		}
+		if field.Kind() != reflect.Struct {
+			continue
+		}
+		if nested, path, err := lookupEnvField(field, rest, joinPath(prefix, fieldName), appended); err == nil {
+			return nested, path, nil
+		}
+	}
+
+	return reflect.Value{}, "", errors.New("no such configuration field")
+}
+
+// lookupEnvElement resolves "<index>_<field>" against a list of objects. The
+// index one past the end appends an element.
+func lookupEnvElement(list reflect.Value, name, prefix string, appended func(path string)) (reflect.Value, string, error) {
+	indexPart, rest, ok := strings.Cut(name, "_")
+	index, err := strconv.Atoi(indexPart)
+	if !ok || err != nil || index < 0 {
+		return reflect.Value{}, "", errors.New("no such configuration field")
+	}
+	if index > list.Len() {
+		return reflect.Value{}, "", fmt.Errorf("index %d is past the end of a list of %d", index, list.Len())
+	}
+
+	elementPath := joinPath(prefix, indexPart)
+	if index < list.Len() {
+		return lookupEnvField(list.Index(index), rest, elementPath, appended)
+	}
+
+	list.Set(reflect.Append(list, reflect.New(list.Type().Elem()).Elem()))
+	field, path, err := lookupEnvField(list.Index(index), rest, elementPath, appended)
+	if err != nil {
+		list.Set(list.Slice(0, index))
+		return reflect.Value{}, "", err
+	}
+	appended(elementPath)
+	return field, path, nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Pointer {
+		target := reflect.New(field.Type().Elem())
+		if err := setFromEnv(target.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(target)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+		return nil
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+	}
+
+	target := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+		return err
+	}
+	field.Set(target.Elem())
+	return nil
+}
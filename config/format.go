@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configSource is a configuration file as a JSON document. YAML and TOML
+// files are converted to JSON so every format is decoded with the same
+// rules, defaults and error messages.
+type configSource struct {
+	path      string
+	data      []byte
+	converted bool
+	// lines maps JSON paths such as "routes.0.timeout" to their line in the
+	// original file, when the format provides positions.
+	lines map[string]int
+	// env maps JSON paths to the PROXY_* variable that overrode them.
+	env map[string]string
+}
+
+func readConfigSource(path string) (configSource, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return configSource{}, fmt.Errorf("error while opening the configuration file: %w", err)
+	}
+
+	src := configSource{path: path, data: raw}
+	var tree interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", "":
+		return src, nil
+	case ".yaml", ".yml":
+		var document yaml.Node
+		if err := yaml.Unmarshal(raw, &document); err != nil {
+			return configSource{}, fmt.Errorf("%s: %w", path, err)
+		}
+		src.lines = make(map[string]int)
+		if tree, err = yamlTree(&document, "", src.lines); err != nil {
+			return configSource{}, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(raw), &tree); err != nil {
+			return configSource{}, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return configSource{}, fmt.Errorf("%s: unsupported configuration format %q, use .json, .yaml, .yml or .toml", path, filepath.Ext(path))
+	}
+
+	if src.data, err = json.Marshal(tree); err != nil {
+		return configSource{}, fmt.Errorf("%s: %w", path, err)
+	}
+	src.converted = true
+	return src, nil
+}
+
+// yamlTree converts a YAML node to the value encoding/json would produce for
+// the same document and records the line of every path it visits.
+func yamlTree(node *yaml.Node, path string, lines map[string]int) (interface{}, error) {
+	if path != "" {
+		lines[path] = node.Line
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return map[string]interface{}{}, nil
+		}
+		return yamlTree(node.Content[0], path, lines)
+	case yaml.AliasNode:
+		return yamlTree(node.Alias, path, lines)
+	case yaml.MappingNode:
+		object := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			value, err := yamlTree(node.Content[i+1], joinPath(path, key), lines)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+		}
+		return object, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			value, err := yamlTree(item, joinPath(path, strconv.Itoa(i)), lines)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		return value, nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// decodeError points a decoding error at its line, and at the column for
+// JSON files, and names the offending field for type mismatches.
+func (src configSource) decodeError(err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("field %s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	default:
+		return fmt.Errorf("%s: %w", src.path, err)
+	}
+
+	if src.converted {
+		if typeErr != nil {
+			if line, ok := src.lines[typeErr.Field]; ok {
+				return fmt.Errorf("%s:%d: %w", src.path, line, err)
+			}
+		}
+		return fmt.Errorf("%s: %w", src.path, err)
+	}
+
+	// Offsets point just past the byte where decoding failed.
+	line, column := 1, 1
+	for _, c := range src.data[:min(max(int(offset)-1, 0), len(src.data))] {
+		if c == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Errorf("%s:%d:%d: %w", src.path, line, column, err)
+}
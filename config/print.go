@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// WriteJSON writes the effective configuration in the file format, with
+// defaults and environment overrides applied, so it can be inspected or
+// saved as a config file. The sticky cookie secret and the values of the
+// tracing headers, which usually carry collector credentials, are redacted.
+func (p ProxyConfig) WriteJSON(w io.Writer) error {
+	if p.StickyCookieSecret != "" {
+		p.StickyCookieSecret = redacted
+	}
+	if len(p.Tracing.Headers) > 0 {
+		headers := make(map[string]string, len(p.Tracing.Headers))
+		for name := range p.Tracing.Headers {
+			headers[name] = redacted
+		}
+		p.Tracing.Headers = headers
+	}
+
+	data, err := json.MarshalIndent(fileValue(reflect.ValueOf(p)), "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	statusRangeType = reflect.TypeOf(StatusRange{})
+)
+
+// fileValue converts a configuration value to its file representation:
+// durations and status ranges become the strings the loader parses.
+func fileValue(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Type() == statusRangeType:
+		statusRange := v.Interface().(StatusRange)
+		return fmt.Sprintf("%d-%d", statusRange.Min, statusRange.Max)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		object := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			if name := jsonName(v.Type().Field(i)); name != "" && name != "-" {
+				object[name] = fileValue(v.Field(i))
+			}
+		}
+		return object
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = fileValue(v.Index(i))
+		}
+		return list
+	default:
+		return v.Interface()
+	}
+}
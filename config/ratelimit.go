@@ -0,0 +1,138 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RateLimitConfig struct {
+	// APIKeyHeader carries the key of rules with key "api-key".
+	APIKeyHeader string `json:"api_key_header"`
+	// IdleTimeout drops the counters of keys without requests for that long.
+	IdleTimeout time.Duration         `json:"idle_timeout"`
+	Rules       []RateLimitRuleConfig `json:"rules"`
+}
+
+type RateLimitRuleConfig struct {
+	Name string `json:"name"`
+	// Key is "client-ip", "api-key", "route" or "header:<name>".
+	Key       string        `json:"key"`
+	Algorithm string        `json:"algorithm"`
+	Limit     int           `json:"limit"`
+	Period    time.Duration `json:"period"`
+	// Burst is the token bucket size, limit when 0.
+	Burst int `json:"burst"`
+	// Routes restricts the rule to these routes, "" being the default pool;
+	// empty applies it to every request.
+	Routes []string `json:"routes"`
+}
+
+type rateLimitJSON struct {
+	APIKeyHeader string `json:"api_key_header"`
+	IdleTimeout  string `json:"idle_timeout"`
+	Rules        []struct {
+		Name      string   `json:"name"`
+		Key       string   `json:"key"`
+		Algorithm string   `json:"algorithm"`
+		Limit     int      `json:"limit"`
+		Period    string   `json:"period"`
+		Burst     int      `json:"burst"`
+		Routes    []string `json:"routes"`
+	} `json:"rules"`
+}
+
+var defaultRateLimit = RateLimitConfig{
+	APIKeyHeader: "X-API-Key",
+	IdleTimeout:  10 * time.Minute,
+}
+
+func (r *rateLimitJSON) toConfig() (RateLimitConfig, error) {
+	rateLimit := defaultRateLimit
+	if r.APIKeyHeader != "" {
+		rateLimit.APIKeyHeader = r.APIKeyHeader
+	}
+
+	var errs fieldErrors
+	if r.IdleTimeout != "" {
+		idleTimeout, err := time.ParseDuration(r.IdleTimeout)
+		if err != nil {
+			errs.add("idle_timeout", "invalid duration %q", r.IdleTimeout)
+		} else {
+			rateLimit.IdleTimeout = idleTimeout
+		}
+	}
+
+	for i, rule := range r.Rules {
+		cfg := RateLimitRuleConfig{
+			Name:      rule.Name,
+			Key:       rule.Key,
+			Algorithm: rule.Algorithm,
+			Limit:     rule.Limit,
+			Burst:     rule.Burst,
+			Routes:    rule.Routes,
+		}
+		if cfg.Algorithm == "" {
+			cfg.Algorithm = "token-bucket"
+		}
+		if rule.Period != "" {
+			period, err := time.ParseDuration(rule.Period)
+			if err != nil {
+				errs.add(joinPath(joinPath("rules", strconv.Itoa(i)), "period"), "invalid duration %q", rule.Period)
+			}
+			cfg.Period = period
+		}
+		rateLimit.Rules = append(rateLimit.Rules, cfg)
+	}
+	return rateLimit, errs.err()
+}
+
+func (r *RateLimitConfig) Validate() error {
+	var errs fieldErrors
+
+	if r.APIKeyHeader == "" {
+		errs.add("api_key_header", "cannot be empty")
+	}
+	if r.IdleTimeout <= 0 {
+		errs.add("idle_timeout", "must be positive")
+	}
+
+	names := make(map[string]int)
+	for i, rule := range r.Rules {
+		path := joinPath("rules", strconv.Itoa(i))
+		if rule.Name == "" {
+			errs.add(joinPath(path, "name"), "cannot be empty")
+		} else if first, ok := names[rule.Name]; ok {
+			errs.add(joinPath(path, "name"), "duplicate of rule %d (%s)", first, rule.Name)
+		} else {
+			names[rule.Name] = i
+		}
+
+		switch {
+		case rule.Key == "client-ip", rule.Key == "api-key", rule.Key == "route":
+		case strings.HasPrefix(rule.Key, "header:") && strings.TrimPrefix(rule.Key, "header:") != "":
+		default:
+			errs.add(joinPath(path, "key"), "must be 'client-ip', 'api-key', 'route' or 'header:<name>', got %q", rule.Key)
+		}
+		if rule.Algorithm != "token-bucket" && rule.Algorithm != "sliding-window" {
+			errs.add(joinPath(path, "algorithm"), "must be 'token-bucket' or 'sliding-window', got %q", rule.Algorithm)
+		}
+		if rule.Limit < 1 {
+			errs.add(joinPath(path, "limit"), "must be at least 1")
+		}
+		if rule.Period <= 0 {
+			errs.add(joinPath(path, "period"), "must be positive")
+		} else if r.IdleTimeout > 0 && rule.Period > r.IdleTimeout {
+			// An evicted key starts over at its full limit, so the state
+			// has to outlive the period it limits.
+			errs.add(joinPath(path, "period"), "cannot be longer than idle_timeout (%v)", r.IdleTimeout)
+		}
+		if rule.Burst < 0 {
+			errs.add(joinPath(path, "burst"), "cannot be negative")
+		} else if rule.Burst > 0 && rule.Algorithm == "sliding-window" {
+			errs.add(joinPath(path, "burst"), "only applies to the token-bucket algorithm")
+		}
+	}
+
+	return errs.err()
+}
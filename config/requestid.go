@@ -0,0 +1,54 @@
+package config
+
+import "regexp"
+
+type RequestIDConfig struct {
+	Enabled bool   `json:"enabled"`
+	Header  string `json:"header"`
+	// AcceptIncoming keeps a valid ID sent by the client or an upstream
+	// proxy instead of always generating one.
+	AcceptIncoming bool `json:"accept_incoming"`
+}
+
+type requestIDJSON struct {
+	Enabled        *bool  `json:"enabled"`
+	Header         string `json:"header"`
+	AcceptIncoming *bool  `json:"accept_incoming"`
+}
+
+var defaultRequestID = RequestIDConfig{
+	Enabled:        true,
+	Header:         "X-Request-ID",
+	AcceptIncoming: true,
+}
+
+func (r *requestIDJSON) toConfig() RequestIDConfig {
+	requestID := defaultRequestID
+	if r.Enabled != nil {
+		requestID.Enabled = *r.Enabled
+	}
+	if r.Header != "" {
+		requestID.Header = r.Header
+	}
+	if r.AcceptIncoming != nil {
+		requestID.AcceptIncoming = *r.AcceptIncoming
+	}
+	return requestID
+}
+
+// headerNamePattern matches an RFC 9110 field name token.
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+func (r *RequestIDConfig) Validate() error {
+	if !r.Enabled {
+		return nil
+	}
+
+	var errs fieldErrors
+
+	if !headerNamePattern.MatchString(r.Header) {
+		errs.add("header", "invalid header name %q", r.Header)
+	}
+
+	return errs.err()
+}
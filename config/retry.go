@@ -0,0 +1,156 @@
+package config
+
+import (
+	"strconv"
+	"time"
+)
+
+type RetryConfig struct {
+	// MaxRetries is the number of attempts after the first; 0 disables
+	// retries.
+	MaxRetries int `json:"max_retries"`
+	// RetryOn lists the failures retried: "connect-error" and "timeout".
+	RetryOn []string `json:"retry_on"`
+	// Statuses are backend response codes that are retried too.
+	Statuses      []int         `json:"statuses"`
+	PerTryTimeout time.Duration `json:"per_try_timeout"`
+	Backoff       time.Duration `json:"backoff"`
+	MaxBackoff    time.Duration `json:"max_backoff"`
+	// NonIdempotent allows retrying methods such as POST when the body fits
+	// in MaxBodyBytes and can be replayed.
+	NonIdempotent bool  `json:"non_idempotent"`
+	MaxBodyBytes  int64 `json:"max_body_bytes"`
+	// BudgetRatio caps retries to this share of the pool's requests over
+	// the last ten seconds, with at least BudgetMinPerSecond per second.
+	BudgetRatio        float64 `json:"budget_ratio"`
+	BudgetMinPerSecond int     `json:"budget_min_per_second"`
+}
+
+type retryJSON struct {
+	MaxRetries         *int     `json:"max_retries"`
+	RetryOn            []string `json:"retry_on"`
+	Statuses           []int    `json:"statuses"`
+	PerTryTimeout      string   `json:"per_try_timeout"`
+	Backoff            string   `json:"backoff"`
+	MaxBackoff         string   `json:"max_backoff"`
+	NonIdempotent      *bool    `json:"non_idempotent"`
+	MaxBodyBytes       *int64   `json:"max_body_bytes"`
+	BudgetRatio        *float64 `json:"budget_ratio"`
+	BudgetMinPerSecond *int     `json:"budget_min_per_second"`
+}
+
+var defaultRetry = RetryConfig{
+	RetryOn:            []string{"connect-error", "timeout"},
+	Statuses:           []int{502, 503, 504},
+	Backoff:            25 * time.Millisecond,
+	MaxBackoff:         250 * time.Millisecond,
+	MaxBodyBytes:       64 << 10,
+	BudgetRatio:        0.2,
+	BudgetMinPerSecond: 3,
+}
+
+// toConfig works like forwardingJSON.toConfig: unset fields keep the defaults.
+func (r *retryJSON) toConfig(defaults RetryConfig) (RetryConfig, error) {
+	if r == nil {
+		return defaults, nil
+	}
+
+	retry := defaults
+	if r.MaxRetries != nil {
+		retry.MaxRetries = *r.MaxRetries
+	}
+	if r.RetryOn != nil {
+		retry.RetryOn = r.RetryOn
+	}
+	if r.Statuses != nil {
+		retry.Statuses = r.Statuses
+	}
+	if r.NonIdempotent != nil {
+		retry.NonIdempotent = *r.NonIdempotent
+	}
+	if r.MaxBodyBytes != nil {
+		retry.MaxBodyBytes = *r.MaxBodyBytes
+	}
+	if r.BudgetRatio != nil {
+		retry.BudgetRatio = *r.BudgetRatio
+	}
+	if r.BudgetMinPerSecond != nil {
+		retry.BudgetMinPerSecond = *r.BudgetMinPerSecond
+	}
+
+	var errs fieldErrors
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"per_try_timeout", r.PerTryTimeout, &retry.PerTryTimeout},
+		{"backoff", r.Backoff, &retry.Backoff},
+		{"max_backoff", r.MaxBackoff, &retry.MaxBackoff},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			errs.add(d.name, "invalid duration %q", d.value)
+			continue
+		}
+		*d.dest = parsed
+	}
+	return retry, errs.err()
+}
+
+// RetriesOn reports whether failure is one of the retried failures.
+func (r *RetryConfig) RetriesOn(failure string) bool {
+	for _, on := range r.RetryOn {
+		if on == failure {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RetryConfig) Validate() error {
+	var errs fieldErrors
+
+	if r.MaxRetries < 0 || r.MaxRetries > 10 {
+		errs.add("max_retries", "must be between 0 and 10")
+	}
+
+	for i, on := range r.RetryOn {
+		if on != "connect-error" && on != "timeout" {
+			errs.add(joinPath("retry_on", strconv.Itoa(i)), "must be 'connect-error' or 'timeout', got %q", on)
+		}
+	}
+
+	for i, status := range r.Statuses {
+		if status < 400 || status > 599 {
+			errs.add(joinPath("statuses", strconv.Itoa(i)), "must be between 400 and 599, got %d", status)
+		}
+	}
+
+	if r.PerTryTimeout < 0 {
+		errs.add("per_try_timeout", "cannot be negative")
+	}
+	if r.Backoff < 0 {
+		errs.add("backoff", "cannot be negative")
+	}
+	if r.MaxBackoff < r.Backoff {
+		errs.add("max_backoff", "must not be shorter than backoff")
+	}
+
+	if r.MaxBodyBytes < 0 {
+		errs.add("max_body_bytes", "cannot be negative")
+	}
+
+	if r.BudgetRatio < 0 || r.BudgetRatio > 1 {
+		errs.add("budget_ratio", "must be between 0 and 1")
+	}
+	if r.BudgetMinPerSecond < 0 {
+		errs.add("budget_min_per_second", "cannot be negative")
+	}
+
+	return errs.err()
+}
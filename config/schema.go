@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+const durationPattern = `^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+// durationFields are the file-format string fields parsed as durations.
+var durationFields = map[string]bool{
+	"backend_timeout":         true,
+	"health_check_frequency":  true,
+	"sticky_session_ttl":      true,
+	"timeout":                 true,
+	"jitter":                  true,
+	"idle_conn_timeout":       true,
+	"dial_timeout":            true,
+	"keep_alive":              true,
+	"tls_handshake_timeout":   true,
+	"response_header_timeout": true,
+	"window":                  true,
+	"base_ejection_time":      true,
+	"max_ejection_time":       true,
+	"rotate_every":            true,
+	"flush_interval":          true,
+	"per_try_timeout":         true,
+	"backoff":                 true,
+	"max_backoff":             true,
+	"open_duration":           true,
+	"idle_timeout":            true,
+	"period":                  true,
+}
+
+var enumFields = map[string][]string{
+	"strategy":            {"round-robin", "least-conn", "consistent-hash", "p2c-ewma"},
+	"health_check_method": {"tcp", "http"},
+	"sticky_session_mode": {"ip", "cookie", "app-cookie"},
+	"x_forwarded":         {"append", "overwrite", "off"},
+	"forwarded":           {"append", "overwrite", "off"},
+	"role":                {"read-only", "read-write"},
+	"format":              {"json", "common", "combined", "template"},
+	"output":              {"stdout", "file", "syslog"},
+	"algorithm":           {"token-bucket", "sliding-window"},
+	"client_ip_header":    {"x-forwarded-for", "forwarded"},
+}
+
+// requiredFields are the top-level settings without a default.
+var requiredFields = []string{
+	"port",
+	"admin_port",
+	"strategy",
+	"health_check_frequency",
+	"health_check_method",
+	"backend_timeout",
+}
+
+// JSONSchema describes the configuration file format, so editors can
+// validate and complete JSON and YAML configuration files.
+func JSONSchema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(proxyConfigJSON{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Reverse proxy configuration"
+	schema["required"] = requiredFields
+	return json.MarshalIndent(schema, "", "    ")
+}
+
+func schemaFor(t reflect.Type, name string) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := make(map[string]interface{})
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			if fieldName := jsonName(t.Field(i)); fieldName != "" && fieldName != "-" {
+				properties[fieldName] = schemaFor(t.Field(i).Type, fieldName)
+			}
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaFor(t.Elem(), "")
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), "")
+	case reflect.String:
+		schema["type"] = "string"
+		if durationFields[name] {
+			schema["pattern"] = durationPattern
+		}
+		if values, ok := enumFields[name]; ok {
+			schema["enum"] = values
+		}
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Float64:
+		schema["type"] = "number"
+	}
+	return schema
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"net/url"
+	"time"
+)
+
+type TracingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint is the OTLP/HTTP traces URL of the collector.
+	Endpoint    string            `json:"endpoint"`
+	ServiceName string            `json:"service_name"`
+	SampleRatio float64           `json:"sample_ratio"`
+	Headers     map[string]string `json:"headers"`
+	BatchSize   int               `json:"batch_size"`
+	// FlushInterval is the longest a span waits in the export queue.
+	FlushInterval time.Duration `json:"flush_interval"`
+	Timeout       time.Duration `json:"timeout"`
+}
+
+type tracingJSON struct {
+	Enabled       bool              `json:"enabled"`
+	Endpoint      string            `json:"endpoint"`
+	ServiceName   string            `json:"service_name"`
+	SampleRatio   *float64          `json:"sample_ratio"`
+	Headers       map[string]string `json:"headers"`
+	BatchSize     int               `json:"batch_size"`
+	FlushInterval string            `json:"flush_interval"`
+	Timeout       string            `json:"timeout"`
+}
+
+var defaultTracing = TracingConfig{
+	Endpoint:      "http://localhost:4318/v1/traces",
+	ServiceName:   "reverse-proxy",
+	SampleRatio:   1,
+	BatchSize:     512,
+	FlushInterval: 5 * time.Second,
+	Timeout:       10 * time.Second,
+}
+
+func (t *tracingJSON) toConfig() (TracingConfig, error) {
+	tracing := defaultTracing
+	tracing.Enabled = t.Enabled
+	if t.Endpoint != "" {
+		tracing.Endpoint = t.Endpoint
+	}
+	if t.ServiceName != "" {
+		tracing.ServiceName = t.ServiceName
+	}
+	if t.SampleRatio != nil {
+		tracing.SampleRatio = *t.SampleRatio
+	}
+	tracing.Headers = t.Headers
+	if t.BatchSize != 0 {
+		tracing.BatchSize = t.BatchSize
+	}
+
+	var errs fieldErrors
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"flush_interval", t.FlushInterval, &tracing.FlushInterval},
+		{"timeout", t.Timeout, &tracing.Timeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			errs.add(d.name, "invalid duration %q", d.value)
+			continue
+		}
+		*d.dest = parsed
+	}
+	return tracing, errs.err()
+}
+
+func (t *TracingConfig) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	var errs fieldErrors
+
+	if parsed, err := url.Parse(t.Endpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs.add("endpoint", "must be an http or https URL, got %q", t.Endpoint)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		errs.add("sample_ratio", "must be between 0 and 1")
+	}
+	if t.BatchSize < 1 {
+		errs.add("batch_size", "must be at least 1")
+	}
+	if t.FlushInterval <= 0 {
+		errs.add("flush_interval", "must be positive")
+	}
+	if t.Timeout <= 0 {
+		errs.add("timeout", "must be positive")
+	}
+
+	return errs.err()
+}
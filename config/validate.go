@@ -0,0 +1,350 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a problem with the configuration value at Path, a JSON path
+// such as "routes.1.backends.0.url". Line is its line in the configuration
+// file when known.
+type FieldError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// fieldErrors collects every problem of a configuration section so they can
+// be reported together instead of one per run.
+type fieldErrors []error
+
+func (errs *fieldErrors) add(path, format string, args ...interface{}) {
+	*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// nest adds the problems of a nested section, whose paths are relative to
+// prefix.
+func (errs *fieldErrors) nest(prefix string, err error) {
+	if err == nil {
+		return
+	}
+
+	list := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		list = joined.Unwrap()
+	}
+	for _, e := range list {
+		var fieldErr *FieldError
+		if errors.As(e, &fieldErr) {
+			nested := *fieldErr
+			nested.Path = joinPath(prefix, fieldErr.Path)
+			*errs = append(*errs, &nested)
+		} else {
+			*errs = append(*errs, &FieldError{Path: prefix, Message: e.Error()})
+		}
+	}
+}
+
+func (errs fieldErrors) err() error {
+	return errors.Join(errs...)
+}
+
+// locate prefixes every error with the file name and, where known, the line
+// of its path or of the closest enclosing path. Errors in a value set from
+// the environment name the variable instead.
+func (src configSource) locate(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var located fieldErrors
+	var list []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		list = joined.Unwrap()
+	} else {
+		list = []error{err}
+	}
+
+	for _, e := range list {
+		var fieldErr *FieldError
+		if !errors.As(e, &fieldErr) {
+			located = append(located, fmt.Errorf("%s: %w", src.path, e))
+			continue
+		}
+		if name := src.envVariable(fieldErr.Path); name != "" {
+			located = append(located, fmt.Errorf("env %s: %w", name, fieldErr))
+			continue
+		}
+		for path := fieldErr.Path; path != "" && fieldErr.Line == 0; path = parentPath(path) {
+			fieldErr.Line = src.lines[path]
+		}
+		if fieldErr.Line > 0 {
+			located = append(located, fmt.Errorf("%s:%d: %w", src.path, fieldErr.Line, fieldErr))
+		} else {
+			located = append(located, fmt.Errorf("%s: %w", src.path, fieldErr))
+		}
+	}
+	return located.err()
+}
+
+// envVariable returns the variable that set path or an enclosing path.
+func (src configSource) envVariable(path string) string {
+	for ; path != ""; path = parentPath(path) {
+		if name, ok := src.env[path]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' {
+			return path[:i]
+		}
+	}
+	return ""
+}
+
+// jsonLines maps the JSON path of every key and array element to its line.
+func jsonLines(data []byte) map[string]int {
+	type frame struct {
+		path   string
+		object bool
+		key    string
+		index  int
+	}
+
+	lines := make(map[string]int)
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var stack []*frame
+	expectKey := false
+
+	// valuePath is the path of the value about to be read.
+	valuePath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		top := stack[len(stack)-1]
+		if top.object {
+			return joinPath(top.path, top.key)
+		}
+		return joinPath(top.path, strconv.Itoa(top.index))
+	}
+	// valueDone moves the enclosing container past the value just read.
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := stack[len(stack)-1]
+		expectKey = top.object
+		if !top.object {
+			top.index++
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return lines
+		}
+		offset := decoder.InputOffset()
+
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{', '[':
+				path := valuePath()
+				if len(stack) > 0 && !stack[len(stack)-1].object {
+					lines[path] = lineAt(offset)
+				}
+				stack = append(stack, &frame{path: path, object: t == '{'})
+				expectKey = t == '{'
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+				valueDone()
+			}
+		case string:
+			if expectKey {
+				top := stack[len(stack)-1]
+				top.key = t
+				lines[joinPath(top.path, t)] = lineAt(offset)
+				expectKey = false
+				continue
+			}
+			if len(stack) > 0 && !stack[len(stack)-1].object {
+				lines[valuePath()] = lineAt(offset)
+			}
+			valueDone()
+		default:
+			if len(stack) > 0 && !stack[len(stack)-1].object {
+				lines[valuePath()] = lineAt(offset)
+			}
+			valueDone()
+		}
+	}
+}
+
+// unknownFields reports every key of the document that does not exist in
+// the file format, with the closest known key as a suggestion.
+func unknownFields(data []byte) error {
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+
+	var errs fieldErrors
+	checkFields(tree, reflect.TypeOf(proxyConfigJSON{}), "", &errs)
+	return errs.err()
+}
+
+func checkFields(value interface{}, t reflect.Type, path string, errs *fieldErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if name := jsonName(t.Field(i)); name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldType, ok := fields[key]
+			if !ok {
+				if suggestion := closestField(key, fields); suggestion != "" {
+					errs.add(joinPath(path, key), "unknown field, did you mean %q?", suggestion)
+				} else {
+					errs.add(joinPath(path, key), "unknown field")
+				}
+				continue
+			}
+			checkFields(v[key], fieldType, joinPath(path, key), errs)
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, item := range v {
+			checkFields(item, t.Elem(), joinPath(path, strconv.Itoa(i)), errs)
+		}
+	}
+}
+
+// closestField returns the known field within a small edit distance of key.
+func closestField(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 4
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// backendKey normalizes a backend URL so spellings of the same address
+// compare equal: the host is lowercased, the default port of the scheme and
+// a trailing slash are dropped.
+func backendKey(u *url.URL) string {
+	normalized := *u
+	normalized.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (normalized.Scheme == "http" && port == "80") || (normalized.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	normalized.Host = host
+	if port != "" {
+		normalized.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		normalized.Host = "[" + host + "]"
+	}
+	normalized.Path = strings.TrimSuffix(u.Path, "/")
+	normalized.RawPath = ""
+	return normalized.String()
+}
+
+// validateBackends checks the URL, weight and uniqueness of every backend
+// of a pool.
+func validateBackends(backends []BackendConfig) error {
+	var errs fieldErrors
+	seen := make(map[string]int)
+
+	for i, backend := range backends {
+		path := strconv.Itoa(i)
+
+		parsed, err := url.Parse(backend.URL)
+		switch {
+		case backend.URL == "":
+			errs.add(joinPath(path, "url"), "is required")
+		case err != nil:
+			errs.add(joinPath(path, "url"), "invalid URL: %v", err)
+		case parsed.Scheme != "http" && parsed.Scheme != "https":
+			errs.add(joinPath(path, "url"), "scheme must be http or https, got %q", backend.URL)
+		case parsed.Host == "":
+			errs.add(joinPath(path, "url"), "missing host in %q", backend.URL)
+		default:
+			key := backendKey(parsed)
+			if first, ok := seen[key]; ok {
+				errs.add(joinPath(path, "url"), "duplicate of backend %d (%s)", first, backend.URL)
+			} else {
+				seen[key] = i
+			}
+		}
+
+		if backend.Weight < 0 {
+			errs.add(joinPath(path, "weight"), "cannot be negative")
+		}
+	}
+	return errs.err()
+}
@@ -2,26 +2,101 @@ package health
 
 import (
 	"context"
+	"io"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
+	"reverseproxy.com/metrics"
 	"reverseproxy.com/proxy"
 )
 
+const maxHealthBodySize = 64 * 1024
+
+var (
+	checksTotal = metrics.NewCounterVec("proxy_health_checks_total",
+		"Active health checks by backend and result (pass or fail).",
+		"backend", "result")
+	checkDuration = metrics.NewHistogramVec("proxy_health_check_duration_seconds",
+		"Duration of active health checks by backend.",
+		metrics.DefaultBuckets, "backend")
+)
+
+func init() {
+	metrics.Default.MustRegister(checksTotal, checkDuration)
+}
+
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+type CheckOptions struct {
+	Path               string
+	Method             string
+	ExpectedStatuses   []StatusRange
+	ExpectedBody       string
+	ExpectedBodyRegex  *regexp.Regexp
+	Headers            map[string]string
+	Host               string
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	Jitter             time.Duration
+}
+
 type HealthChecker struct{
 	pool   *proxy.ServerPool
 	interval time.Duration
 	timeout time.Duration
 	method string
+	opts CheckOptions
+	client *http.Client
+
+	mux sync.Mutex
+	streaks map[*proxy.Backend]*streak
 }
 
-func NewHealthChecker(pool *proxy.ServerPool,interval,timeout time.Duration,method string) *HealthChecker{
+// streak counts consecutive results of the same kind for one backend.
+// alive is the state the checker last left the backend in, so a change
+// made elsewhere (passive detection, the admin API) can be noticed.
+type streak struct {
+	successes int
+	failures  int
+	alive     bool
+	seen      bool
+}
+
+func NewHealthChecker(pool *proxy.ServerPool,interval,timeout time.Duration,method string,opts CheckOptions) *HealthChecker{
+	if opts.Path == "" {
+		opts.Path = "/health"
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	if opts.HealthyThreshold < 1 {
+		opts.HealthyThreshold = 1
+	}
+	if opts.UnhealthyThreshold < 1 {
+		opts.UnhealthyThreshold = 1
+	}
+
 	return &HealthChecker{
 		pool: pool,
 		interval: interval,
 		timeout: timeout,
 		method: method,
+		opts: opts,
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		streaks: make(map[*proxy.Backend]*streak),
 	}
 }
 
@@ -45,18 +120,45 @@ func (hc *HealthChecker) Start(ctx context.Context){
 }
 
 func (hc *HealthChecker) checkAllBackends(){
-	for _,backend := range hc.pool.Backends{
+	hc.pool.Mux.RLock()
+	backends := append([]*proxy.Backend(nil), hc.pool.Backends...)
+	hc.pool.Mux.RUnlock()
+
+	hc.mux.Lock()
+	current := make(map[*proxy.Backend]bool, len(backends))
+	for _, backend := range backends {
+		current[backend] = true
+	}
+	for backend := range hc.streaks {
+		if !current[backend] {
+			delete(hc.streaks, backend)
+		}
+	}
+	hc.mux.Unlock()
+
+	for _,backend := range backends{
 		go hc.checkBackend(backend)
 	}
 }
 
 func (hc *HealthChecker) checkBackend(backend *proxy.Backend){
+	// Spread checks over the jitter window so proxies started together
+	// do not probe the backends in lockstep.
+	if hc.opts.Jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(hc.opts.Jitter))))
+	}
 
 	wasAlive := backend.IsAlive()
 
-	isAlive := hc.isBackendAlive(backend)
+	start := time.Now()
+	passed := hc.isBackendAlive(backend)
+	observeCheck(backend, passed, time.Since(start))
+
+	isAlive := hc.applyThresholds(backend, wasAlive, passed)
 
-	backend.SetAlive(isAlive)
+	if isAlive != wasAlive {
+		backend.SetAlive(isAlive)
+	}
 
 	if wasAlive && !isAlive{
 		log.Printf("Backend %s is Down",backend.URL.String())
@@ -65,6 +167,53 @@ func (hc *HealthChecker) checkBackend(backend *proxy.Backend){
 	}
 }
 
+func observeCheck(backend *proxy.Backend, passed bool, elapsed time.Duration) {
+	result := "fail"
+	if passed {
+		result = "pass"
+	}
+	checksTotal.WithLabelValues(backend.URL.String(), result).Inc()
+	checkDuration.WithLabelValues(backend.URL.String()).Observe(elapsed.Seconds())
+}
+
+// applyThresholds only flips the state after HealthyThreshold consecutive
+// successes or UnhealthyThreshold consecutive failures.
+func (hc *HealthChecker) applyThresholds(backend *proxy.Backend, wasAlive, passed bool) bool {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+
+	st, ok := hc.streaks[backend]
+	if !ok {
+		st = &streak{}
+		hc.streaks[backend] = st
+	}
+	// The state changed outside the checker: count from scratch so the
+	// thresholds apply to the new state too.
+	if st.seen && st.alive != wasAlive {
+		st.successes = 0
+		st.failures = 0
+	}
+	st.seen = true
+	st.alive = hc.nextState(st, wasAlive, passed)
+	return st.alive
+}
+
+func (hc *HealthChecker) nextState(st *streak, wasAlive, passed bool) bool {
+	if passed {
+		st.successes++
+		st.failures = 0
+		if !wasAlive && st.successes >= hc.opts.HealthyThreshold {
+			return true
+		}
+	} else {
+		st.failures++
+		st.successes = 0
+		if wasAlive && st.failures >= hc.opts.UnhealthyThreshold {
+			return false
+		}
+	}
+	return wasAlive
+}
 
 func (hc *HealthChecker) isBackendAlive(backend *proxy.Backend) bool{
 	  if hc.method == "tcp"{
@@ -92,22 +241,54 @@ func (hc *HealthChecker) checkTCP(backend *proxy.Backend) bool{
 func (hc *HealthChecker) checkHTTP(backend *proxy.Backend) bool{
 	ctx, cancel := context.WithTimeout(context.Background(),hc.timeout)
 	defer cancel()
-	healthURL :=  backend.URL.String()+"/health"
+	healthURL :=  strings.TrimSuffix(backend.URL.String(), "/")+hc.opts.Path
 	
-	req, err := http.NewRequestWithContext(ctx,"GET",healthURL,nil)
+	req, err := http.NewRequestWithContext(ctx,hc.opts.Method,healthURL,nil)
 	if err != nil{
 		return false
 	}
-
-	client := &http.Client{
-		Timeout: hc.timeout,
+	for name, value := range hc.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if hc.opts.Host != "" {
+		req.Host = hc.opts.Host
 	}
-	resp, err  := client.Do(req)
+
+	resp, err  := hc.client.Do(req)
 	if err != nil{
 		return false
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode < 500
+	if !hc.expectedStatus(resp.StatusCode) {
+		return false
+	}
+
+	if hc.opts.ExpectedBody == "" && hc.opts.ExpectedBodyRegex == nil {
+		return true
+	}
 
-}
\ No newline at end of file
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+	if err != nil {
+		return false
+	}
+	if hc.opts.ExpectedBody != "" && !strings.Contains(string(body), hc.opts.ExpectedBody) {
+		return false
+	}
+	if hc.opts.ExpectedBodyRegex != nil && !hc.opts.ExpectedBodyRegex.Match(body) {
+		return false
+	}
+	return true
+}
+
+func (hc *HealthChecker) expectedStatus(code int) bool {
+	if len(hc.opts.ExpectedStatuses) == 0 {
+		return code < 500
+	}
+	for _, statusRange := range hc.opts.ExpectedStatuses {
+		if code >= statusRange.Min && code <= statusRange.Max {
+			return true
+		}
+	}
+	return false
+}
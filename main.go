@@ -2,69 +2,63 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
 	"syscall"
 	"time"
+	"reverseproxy.com/accesslog"
 	"reverseproxy.com/admin"
 	"reverseproxy.com/config"
 	"reverseproxy.com/health"
+	"reverseproxy.com/metrics"
 	"reverseproxy.com/proxy"
+	"reverseproxy.com/ratelimit"
+	"reverseproxy.com/tracing"
 )
 
 func main() {
-	configuration, err := config.LoadConfiguration()
-	if err != nil {
-		log.Fatalf("Configuration error: %v", err)
-	}
+	configPath := flag.String("config", "config.json", "path of the configuration file (.json, .yaml, .yml or .toml)")
+	validate := flag.Bool("validate", false, "validate the configuration and exit")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, including defaults and PROXY_* overrides, and exit")
+	printSchema := flag.Bool("schema", false, "print the JSON Schema of the configuration file and exit")
+	flag.Parse()
 
-	pool := &proxy.ServerPool{}
-
-	for _, backendConfig := range configuration.BackendsConfig {
-		parsedURL, err := url.Parse(backendConfig.URL)
+	if *printSchema {
+		schema, err := config.JSONSchema()
 		if err != nil {
-			log.Printf("Invalid backend URL %s: %v", backendConfig.URL, err)
-			continue
-		}
-
-		weight := backendConfig.Weight
-		if weight == 0 {
-			weight = 1
-		}
-
-		backend := &proxy.Backend{
-			URL:          parsedURL,
-			Alive:        true,
-			CurrentConns: 0,
-			Weight:       weight,
+			log.Fatalf("Could not generate schema: %v", err)
 		}
-
-		pool.AddBackend(backend)
-		fmt.Printf("Added backend: %s (weight: %d)\n", parsedURL, weight)
+		fmt.Println(string(schema))
+		return
 	}
 
-	fmt.Println("The number of backend servers is:", len(pool.Backends))
-
-	var loadBalancer proxy.LoadBalancer
-
-	if configuration.EnableStickySessions {
-		stickyTTL := configuration.StickySessionTTL
-		if stickyTTL == 0 {
-			stickyTTL = 30 * time.Minute
+	configuration, err := config.LoadConfiguration(*configPath)
+	if *validate {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
 		}
-		loadBalancer = proxy.NewStickySessionPool(pool, stickyTTL)
-		fmt.Println("Sticky sessions enabled with TTL:", stickyTTL)
-	} else {
-		loadBalancer = pool
-		if configuration.Strategy == "least-conn" {
-			fmt.Println("Using least-connections load balancing")
-		} else {
-			fmt.Println("Using weighted round-robin load balancing")
+		fmt.Printf("%s: configuration is valid\n", *configPath)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if *printConfig {
+		if err := configuration.WriteJSON(os.Stdout); err != nil {
+			log.Fatalf("Could not print configuration: %v", err)
 		}
+		return
 	}
 
 	if configuration.SSL.Enabled {
@@ -76,55 +70,293 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	healthChecker := health.NewHealthChecker(pool, configuration.HealthCheckFreq, configuration.Backend_timeout, configuration.HealthCheckMethod)
+	pool := &proxy.ServerPool{}
 	adminAPI := admin.NewAdminAPI(pool)
 
+	runtime := newProxyRuntime(ctx, *configPath, adminAPI, pool)
+	runtime.Start(configuration)
+	runtime.registerMetrics(metrics.Default)
+	adminAPI.SetReloader(runtime)
+	adminAPI.SetRateLimiter(runtime.limiter)
+
+	pool.Mux.RLock()
+	fmt.Println("The number of backend servers is:", len(pool.Backends))
+	pool.Mux.RUnlock()
+
+	adminAuth := adminAuthenticator(configuration.Admin)
+	if !adminAuth.Enabled() {
+		log.Println("Warning: admin API authentication is not configured")
+	}
+	adminAPI.SetAuthenticator(adminAuth)
+
 	adminMux := http.NewServeMux()
 	adminAPI.SetUpRoutes(adminMux)
 
 	adminServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", configuration.Admin_port),
+		Addr:    net.JoinHostPort(configuration.Admin.BindAddress, strconv.Itoa(configuration.Admin_port)),
 		Handler: adminMux,
 	}
+	adminTLS := configuration.Admin.TLS
+	if adminTLS.ClientCAFile != "" {
+		adminServer.TLSConfig, err = adminClientCATLSConfig(adminTLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("Configuration error: %v", err)
+		}
+	}
 
-	go healthChecker.Start(ctx)
+	clientIPResolver, err := proxy.NewClientIPResolver(configuration.TrustedProxies, configuration.ClientIPHeader)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
 
-	proxyMux := http.NewServeMux()
-	proxyMux.HandleFunc("/", proxy.ProxyHandler(loadBalancer, configuration.Backend_timeout, configuration.EnableStickySessions, configuration.Strategy))
+	// Wrapped inside out: requests pass the client IP, request ID, access log
+	// and tracing middlewares in that order before reaching the router.
+	var handler http.Handler = runtime
+	if configuration.Tracing.Enabled {
+		tracer := tracing.New(tracingOptions(configuration.Tracing))
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), configuration.Tracing.Timeout)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				log.Printf("Tracing shutdown error: %v", err)
+			}
+		}()
+		handler = tracer.Middleware(handler)
+		log.Printf("Tracing enabled, exporting to %s", configuration.Tracing.Endpoint)
+	}
+	if configuration.AccessLog.Enabled {
+		accessLog, err := accesslog.New(accessLogOptions(configuration.AccessLog))
+		if err != nil {
+			log.Fatalf("Access log error: %v", err)
+		}
+		defer accessLog.Close()
+		handler = accessLog.Middleware(handler)
+		log.Printf("Access log enabled (format: %s, output: %s)", configuration.AccessLog.Format, configuration.AccessLog.Output)
+	}
+	if configuration.RequestID.Enabled {
+		handler = proxy.RequestIDs{
+			Header:         configuration.RequestID.Header,
+			AcceptIncoming: configuration.RequestID.AcceptIncoming,
+		}.Middleware(handler)
+	}
 
 	proxyServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", configuration.Port),
-		Handler: proxyMux,
+		Handler: clientIPResolver.Middleware(handler),
+	}
+
+	listener, err := net.Listen("tcp", proxyServer.Addr)
+	if err != nil {
+		log.Fatalf("Proxy server error: %v", err)
+	}
+	if configuration.ProxyProtocol {
+		listener = &proxy.ProxyProtocolListener{Listener: listener, Resolver: clientIPResolver}
+		log.Println("PROXY protocol enabled for trusted proxies")
 	}
 
 	go func() {
 		if configuration.SSL.Enabled {
 			log.Printf("Proxy server listening on https://:%d\n", configuration.Port)
-			if err := proxyServer.ListenAndServeTLS(configuration.SSL.CertFile, configuration.SSL.KeyFile); err != nil && err != http.ErrServerClosed {
+			if err := proxyServer.ServeTLS(listener, configuration.SSL.CertFile, configuration.SSL.KeyFile); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("Proxy server error: %v", err)
 			}
 		} else {
 			log.Printf("Proxy server listening on http://:%d\n", configuration.Port)
-			if err := proxyServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := proxyServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("Proxy server error: %v", err)
 			}
 		}
 	}()
 
 	go func() {
-		log.Println("Admin API listening on", adminServer.Addr)
-		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Admin server error: %v", err)
+		if adminTLS.CertFile != "" {
+			log.Println("Admin API listening on https://" + adminServer.Addr)
+			if err := adminServer.ListenAndServeTLS(adminTLS.CertFile, adminTLS.KeyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server error: %v", err)
+			}
+		} else {
+			log.Println("Admin API listening on http://" + adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server error: %v", err)
+			}
 		}
 	}()
 
-	waitForShutdown(cancel, proxyServer, adminServer)
+	waitForShutdown(cancel, runtime, proxyServer, adminServer)
 }
 
-func waitForShutdown(cancel context.CancelFunc, server *http.Server, adminServer *http.Server) {
+func adminAuthenticator(cfg config.AdminConfig) *admin.Authenticator {
+	auth := &admin.Authenticator{}
+	for _, token := range cfg.Tokens {
+		auth.Tokens = append(auth.Tokens, admin.TokenCredential{Name: token.Name, TokenSHA256: token.TokenSHA256, Role: token.Role})
+	}
+	for _, user := range cfg.Users {
+		auth.Users = append(auth.Users, admin.BasicCredential{Username: user.Username, PasswordHash: user.PasswordHash, Role: user.Role})
+	}
+	for _, cert := range cfg.ClientCerts {
+		auth.ClientCerts = append(auth.ClientCerts, admin.ClientCertIdentity{CommonName: cert.CommonName, Role: cert.Role})
+	}
+	return auth
+}
+
+// adminClientCATLSConfig verifies client certificates when one is presented;
+// callers without one can still authenticate with a token or password.
+func adminClientCATLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading admin client_ca_file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("admin client_ca_file %s contains no certificates", caFile)
+	}
+	return &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+func healthCheckOptions(check config.HealthCheckConfig) health.CheckOptions {
+	opts := health.CheckOptions{
+		Path:               check.Path,
+		Method:             check.Method,
+		ExpectedBody:       check.ExpectedBody,
+		Headers:            check.Headers,
+		Host:               check.Host,
+		HealthyThreshold:   check.HealthyThreshold,
+		UnhealthyThreshold: check.UnhealthyThreshold,
+		Jitter:             check.Jitter,
+	}
+	for _, statusRange := range check.ExpectedStatuses {
+		opts.ExpectedStatuses = append(opts.ExpectedStatuses, health.StatusRange{Min: statusRange.Min, Max: statusRange.Max})
+	}
+	if check.ExpectedBodyRegex != "" {
+		opts.ExpectedBodyRegex = regexp.MustCompile(check.ExpectedBodyRegex)
+	}
+	return opts
+}
+
+func accessLogOptions(accessLog config.AccessLogConfig) accesslog.Options {
+	return accesslog.Options{
+		Format:   accessLog.Format,
+		Template: accessLog.Template,
+		Output:   accessLog.Output,
+		File: accesslog.FileOptions{
+			Path:        accessLog.File.Path,
+			MaxSize:     int64(accessLog.File.MaxSizeMB) << 20,
+			RotateEvery: accessLog.File.RotateEvery,
+			MaxBackups:  accessLog.File.MaxBackups,
+		},
+		Syslog: accesslog.SyslogOptions{
+			Network:  accessLog.Syslog.Network,
+			Address:  accessLog.Syslog.Address,
+			Tag:      accessLog.Syslog.Tag,
+			Facility: accessLog.Syslog.Facility,
+		},
+		SampleRate:       accessLog.SampleRate,
+		RouteSampleRates: accessLog.RouteSampleRates,
+	}
+}
+
+func tracingOptions(cfg config.TracingConfig) tracing.Options {
+	return tracing.Options{
+		Endpoint:      cfg.Endpoint,
+		ServiceName:   cfg.ServiceName,
+		SampleRatio:   cfg.SampleRatio,
+		Headers:       cfg.Headers,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+		Timeout:       cfg.Timeout,
+	}
+}
+
+func forwardingOptions(forwarding config.ForwardingConfig) proxy.ForwardingOptions {
+	return proxy.ForwardingOptions{
+		HostHeader:   forwarding.HostHeader,
+		XForwarded:   forwarding.XForwarded,
+		Forwarded:    forwarding.Forwarded,
+		XRealIP:      forwarding.XRealIP,
+		StripHeaders: forwarding.StripHeaders,
+	}
+}
+
+func rateLimitOptions(rateLimit config.RateLimitConfig) ratelimit.Options {
+	rules := make([]ratelimit.Rule, 0, len(rateLimit.Rules))
+	for _, rule := range rateLimit.Rules {
+		rules = append(rules, ratelimit.Rule{
+			Name:      rule.Name,
+			Key:       rule.Key,
+			Algorithm: rule.Algorithm,
+			Limit:     rule.Limit,
+			Period:    rule.Period,
+			Burst:     rule.Burst,
+			Routes:    rule.Routes,
+		})
+	}
+	return ratelimit.Options{
+		Rules:        rules,
+		APIKeyHeader: rateLimit.APIKeyHeader,
+		IdleTimeout:  rateLimit.IdleTimeout,
+	}
+}
+
+// buildStickyOptions uses the configured cookie secret, then the generated
+// one of a previous load, and only generates a new one as a last resort.
+func buildStickyOptions(configuration config.ProxyConfig, generated []byte) proxy.StickyOptions {
+	secret := []byte(configuration.StickyCookieSecret)
+	if len(secret) == 0 {
+		secret = generated
+	}
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Could not generate sticky cookie secret: %v", err)
+		}
+		if configuration.StickySessionMode == "cookie" {
+			log.Println("Warning: sticky_cookie_secret is not set, affinity cookies will not survive a restart")
+		}
+	}
+
+	return proxy.StickyOptions{
+		Mode:       configuration.StickySessionMode,
+		CookieName: configuration.StickyCookieName,
+		Secret:     secret,
+		AppCookie:  configuration.StickyAppCookie,
+	}
+}
+
+func buildLoadBalancer(pool *proxy.ServerPool, stickyEnabled bool, stickyTTL time.Duration, stickyOptions proxy.StickyOptions, strategy string) proxy.LoadBalancer {
+	if stickyEnabled {
+		if stickyTTL == 0 {
+			stickyTTL = 30 * time.Minute
+		}
+		fmt.Printf("Sticky sessions enabled with TTL: %v (mode: %s)\n", stickyTTL, stickyOptions.Mode)
+		return proxy.NewStickySessionPool(pool, stickyTTL, stickyOptions)
+	}
+
+	if strategy == "least-conn" {
+		fmt.Println("Using least-connections load balancing")
+	} else if strategy == "consistent-hash" {
+		fmt.Println("Using consistent-hash load balancing")
+	} else if strategy == "p2c-ewma" {
+		fmt.Println("Using power-of-two-choices EWMA load balancing")
+	} else {
+		fmt.Println("Using weighted round-robin load balancing")
+	}
+	return pool
+}
+
+func waitForShutdown(cancel context.CancelFunc, runtime *proxyRuntime, server *http.Server, adminServer *http.Server) {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Printf("SIGHUP received, reloading configuration")
+		runtime.Reload("signal")
+	}
 
 	log.Printf("Shutdown signal received")
 	cancel()
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+
+	"reverseproxy.com/metrics"
+	"reverseproxy.com/proxy"
+)
+
+// registerMetrics exposes the live state of the running pools. The values
+// are read at scrape time, so pools and backends added or removed by a
+// reload show up without bookkeeping.
+func (rt *proxyRuntime) registerMetrics(registry *metrics.Registry) {
+	registry.MustRegister(
+		metrics.NewGaugeFunc("proxy_backend_in_flight_requests",
+			"Requests currently being proxied to a backend.",
+			[]string{"route", "backend"},
+			func(emit func(float64, ...string)) {
+				rt.eachBackend(func(route string, backend *proxy.Backend) {
+					emit(float64(backend.GetCurrentConns()), route, backend.URL.String())
+				})
+			}),
+		metrics.NewGaugeFunc("proxy_backend_up",
+			"Whether a backend receives traffic: alive, enabled, not ejected and its circuit not open.",
+			[]string{"route", "backend"},
+			func(emit func(float64, ...string)) {
+				rt.eachBackend(func(route string, backend *proxy.Backend) {
+					emit(boolValue(backend.IsAvailable()), route, backend.URL.String())
+				})
+			}),
+		metrics.NewGaugeFunc("proxy_backend_ejected",
+			"Whether a backend is currently ejected by outlier detection.",
+			[]string{"route", "backend"},
+			func(emit func(float64, ...string)) {
+				rt.eachBackend(func(route string, backend *proxy.Backend) {
+					emit(boolValue(backend.IsEjected()), route, backend.URL.String())
+				})
+			}),
+		metrics.NewGaugeFunc("proxy_circuit_breaker_state",
+			"Circuit breaker state of a backend: 1 for the current state, 0 for the others.",
+			[]string{"route", "backend", "state"},
+			func(emit func(float64, ...string)) {
+				rt.eachBackend(func(route string, backend *proxy.Backend) {
+					current := backend.CircuitState()
+					for _, state := range proxy.CircuitStates {
+						emit(boolValue(state == current), route, backend.URL.String(), state.String())
+					}
+				})
+			}),
+		metrics.NewGaugeFunc("proxy_sticky_sessions",
+			"Entries in the sticky session table of a route.",
+			[]string{"route"},
+			func(emit func(float64, ...string)) {
+				rt.mux.Lock()
+				defer rt.mux.Unlock()
+				for _, name := range rt.poolNames() {
+					if sticky, ok := rt.pools[name].balancer.(*proxy.StickySessionPool); ok {
+						emit(float64(sticky.SessionCount()), name)
+					}
+				}
+			}),
+		metrics.NewGaugeFunc("proxy_rate_limit_keys",
+			"Keys with rate limit state, by rule.",
+			[]string{"rule"},
+			func(emit func(float64, ...string)) {
+				for _, rule := range rt.limiter.Rules() {
+					emit(float64(rule.ActiveKeys), rule.Name)
+				}
+			}),
+	)
+}
+
+// eachBackend calls fn for every backend of every pool, ordered by route.
+func (rt *proxyRuntime) eachBackend(fn func(route string, backend *proxy.Backend)) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	for _, name := range rt.poolNames() {
+		pool := rt.pools[name].pool
+		pool.Mux.RLock()
+		backends := append([]*proxy.Backend(nil), pool.Backends...)
+		pool.Mux.RUnlock()
+
+		for _, backend := range backends {
+			fn(name, backend)
+		}
+	}
+}
+
+// poolNames returns the pool names sorted. The caller holds rt.mux.
+func (rt *proxyRuntime) poolNames() []string {
+	names := make([]string, 0, len(rt.pools))
+	for name := range rt.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
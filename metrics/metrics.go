@@ -0,0 +1,389 @@
+// Package metrics implements the small subset of Prometheus instrumentation
+// the proxy needs: counters, gauges and histograms with labels, and a
+// registry that serves them in the text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets suit request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector writes one metric family.
+type Collector interface {
+	Name() string
+	Write(w io.Writer) error
+}
+
+type Registry struct {
+	mux        sync.RWMutex
+	collectors map[string]Collector
+}
+
+// Default is the registry the proxy's own metrics are registered in.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// MustRegister adds collectors and panics if a name is already taken, which
+// is a programming error.
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, c := range collectors {
+		if _, exists := r.collectors[c.Name()]; exists {
+			panic("metrics: duplicate metric " + c.Name())
+		}
+		r.collectors[c.Name()] = c
+	}
+}
+
+// Unregister removes the collector with the given name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mux.Lock()
+	delete(r.collectors, name)
+	r.mux.Unlock()
+}
+
+// Write writes all metric families sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mux.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]Collector, len(names))
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	r.mux.RUnlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, c := range collectors {
+		if err := c.Write(buffered); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// family holds the children of a labelled metric, keyed by their label
+// values.
+type family[T any] struct {
+	name     string
+	help     string
+	typ      string
+	labels   []string
+	newChild func() *T
+
+	mux      sync.RWMutex
+	children map[string]*child[T]
+}
+
+type child[T any] struct {
+	labelValues []string
+	value       *T
+}
+
+func (f *family[T]) Name() string {
+	return f.name
+}
+
+func (f *family[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mux.RLock()
+	c, ok := f.children[key]
+	f.mux.RUnlock()
+	if ok {
+		return c.value
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if c, ok := f.children[key]; ok {
+		return c.value
+	}
+	c = &child[T]{labelValues: append([]string(nil), labelValues...), value: f.newChild()}
+	f.children[key] = c
+	return c.value
+}
+
+// Delete drops the child with the given label values, e.g. when a backend
+// is removed.
+func (f *family[T]) Delete(labelValues ...string) {
+	f.mux.Lock()
+	delete(f.children, strings.Join(labelValues, "\xff"))
+	f.mux.Unlock()
+}
+
+// sorted returns the children ordered by label values for stable output.
+func (f *family[T]) sorted() []*child[T] {
+	f.mux.RLock()
+	children := make([]*child[T], 0, len(f.children))
+	for _, c := range f.children {
+		children = append(children, c)
+	}
+	f.mux.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].labelValues, "\xff") < strings.Join(children[j].labelValues, "\xff")
+	})
+	return children
+}
+
+func (f *family[T]) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+}
+
+func newFamily[T any](name, help, typ string, labels []string, newChild func() *T) *family[T] {
+	return &family[T]{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		newChild: newChild,
+		children: make(map[string]*child[T]),
+	}
+}
+
+// value is a float64 updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increases the counter; negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+type CounterVec struct {
+	*family[Counter]
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newFamily(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+}
+
+func (c *CounterVec) WithLabelValues(labelValues ...string) *Counter {
+	return c.with(labelValues)
+}
+
+func (c *CounterVec) Write(w io.Writer) error {
+	c.writeHeader(w)
+	for _, ch := range c.sorted() {
+		writeSample(w, c.name, c.labels, ch.labelValues, "", "", ch.value.v.load())
+	}
+	return nil
+}
+
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+type GaugeVec struct {
+	*family[Gauge]
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newFamily(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+}
+
+func (g *GaugeVec) WithLabelValues(labelValues ...string) *Gauge {
+	return g.with(labelValues)
+}
+
+func (g *GaugeVec) Write(w io.Writer) error {
+	g.writeHeader(w)
+	for _, ch := range g.sorted() {
+		writeSample(w, g.name, g.labels, ch.labelValues, "", "", ch.value.v.load())
+	}
+	return nil
+}
+
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64
+	count       atomic.Uint64
+	sum         value
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, bound := range h.upperBounds {
+		if v <= bound {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+type HistogramVec struct {
+	*family[Histogram]
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &HistogramVec{newFamily(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{upperBounds: buckets, counts: make([]atomic.Uint64, len(buckets))}
+	})}
+}
+
+func (h *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	return h.with(labelValues)
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.writeHeader(w)
+	for _, ch := range h.sorted() {
+		hist := ch.value
+		// Buckets are cumulative: counts[i] holds every observation <= bound.
+		for i, bound := range hist.upperBounds {
+			writeSample(w, h.name+"_bucket", h.labels, ch.labelValues, "le", formatFloat(bound), float64(hist.counts[i].Load()))
+		}
+		count := float64(hist.count.Load())
+		writeSample(w, h.name+"_bucket", h.labels, ch.labelValues, "le", "+Inf", count)
+		writeSample(w, h.name+"_sum", h.labels, ch.labelValues, "", "", hist.sum.load())
+		writeSample(w, h.name+"_count", h.labels, ch.labelValues, "", "", count)
+	}
+	return nil
+}
+
+// GaugeFunc reports gauges computed at scrape time, for state that already
+// lives elsewhere such as connection counts or table sizes.
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+func NewGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+}
+
+func (g *GaugeFunc) Name() string {
+	return g.name
+}
+
+func (g *GaugeFunc) Write(w io.Writer) error {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, escapeHelp(g.help), g.name)
+	g.collect(func(value float64, labelValues ...string) {
+		writeSample(w, g.name, g.labels, labelValues, "", "", value)
+	})
+	return nil
+}
+
+func writeSample(w io.Writer, name string, labels, labelValues []string, extraLabel, extraValue string, v float64) {
+	var b strings.Builder
+	b.WriteString(name)
+
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labelValues[i]))
+			b.WriteByte('"')
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraLabel)
+			b.WriteString(`="`)
+			b.WriteString(extraValue)
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
@@ -6,7 +6,11 @@ type LoadBalancer interface {
 
 	GetNextValidPeer() *Backend
 		GetLeastConnBackend() *Backend
+	GetConsistentHashBackend(key string) *Backend
+	GetP2CEWMABackend() *Backend
+	GetAlternateBackend(exclude []*Backend) *Backend
 	AddBackend(backend *Backend)
 	SetBackendStatus(uri *url.URL, alive bool)
+	RecordResult(backend *Backend, statusCode int, err error)
 
 }
@@ -1,21 +1,114 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http/httputil"
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// ewmaDecay is the time constant of the latency moving average: a sample
+// observed ewmaDecay ago weighs about 1/e of a fresh one.
+const ewmaDecay = 10 * time.Second
+
 type Backend struct {
+	ID           string   `json:"id"`
 	URL          *url.URL `json:"url"`
 	Alive        bool     `json:"alive"`
 	CurrentConns int64    `json:"current_connections"`
 	mux          sync.RWMutex
 	Weight       int      `json:"weight"`
+	MaxConns     int64             `json:"max_conns"`
+	Tags         []string          `json:"tags"`
+	Metadata     map[string]string `json:"metadata"`
+
+	disabled bool
+	removed  bool
+	// configured is set for backends from the configuration file, which a
+	// reload may remove; backends added through the admin API stay.
+	configured bool
+
+	// currentWeight is the smooth weighted round-robin state, guarded by the
+	// owning ServerPool's Mux.
+	currentWeight int
+
+	ewmaLatency  float64
+	lastObserved time.Time
+
+	outlier outlierState
+	breaker breakerState
+	upSince time.Time
+	drain   drainState
+
+	proxyOnce    sync.Once
+	reverseProxy *httputil.ReverseProxy
+}
+
+// backendID derives an opaque, stable identifier from the backend URL so it
+// can be exposed to clients without revealing the address.
+func backendID(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// initProxy creates the long-lived reverse proxy of the backend. It is a
+// no-op once the proxy exists.
+func (b *Backend) initProxy(opts TransportOptions) {
+	b.proxyOnce.Do(func() {
+		b.reverseProxy = newBackendProxy(b, NewTransport(opts))
+	})
+}
+
+// resetProxy replaces the reverse proxy after the transport settings
+// changed. Requests in flight finish on the old one, whose idle connections
+// are closed.
+func (b *Backend) resetProxy(opts TransportOptions) {
+	b.initProxy(opts)
+	reverseProxy := newBackendProxy(b, NewTransport(opts))
+
+	b.mux.Lock()
+	old := b.reverseProxy
+	b.reverseProxy = reverseProxy
+	b.mux.Unlock()
+
+	if transport, ok := old.Transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// ReverseProxy returns the backend's reverse proxy, creating one with the
+// default transport settings if the backend was not added through a pool.
+func (b *Backend) ReverseProxy() *httputil.ReverseProxy {
+	b.initProxy(DefaultTransportOptions)
+
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.reverseProxy
+}
+
+func (b *Backend) IsEnabled() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return !b.disabled
+}
+
+// GetAttributes returns the fields an admin may change at runtime, read
+// consistently with updates made through ServerPool.UpdateBackend.
+func (b *Backend) GetAttributes() (maxConns int64, tags []string, metadata map[string]string) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.MaxConns, b.Tags, b.Metadata
 }
 
 func (b *Backend) SetAlive(alive bool) {
 	b.mux.Lock()
+	if alive && !b.Alive {
+		b.upSince = time.Now()
+	}
 	b.Alive = alive
 	b.mux.Unlock()
 }
@@ -37,4 +130,28 @@ func (b *Backend) DecrementConnections() {
 
 func (b *Backend) GetCurrentConns() int64 {
 	return atomic.LoadInt64(&b.CurrentConns)
-}
\ No newline at end of file
+}
+
+// ObserveLatency folds a response latency into the backend's moving average.
+// The weight of the previous average decays with the time since the last
+// sample, so an idle backend's old latency does not stick forever.
+func (b *Backend) ObserveLatency(latency time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := time.Now()
+	sample := float64(latency)
+	if b.lastObserved.IsZero() {
+		b.ewmaLatency = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(b.lastObserved)) / float64(ewmaDecay))
+		b.ewmaLatency = b.ewmaLatency*w + sample*(1-w)
+	}
+	b.lastObserved = now
+}
+
+func (b *Backend) GetEWMALatency() time.Duration {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Duration(b.ewmaLatency)
+}
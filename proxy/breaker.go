@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"log"
+	"time"
+)
+
+type BreakerOptions struct {
+	Enabled bool
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row; 0 disables the check.
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when at least MinRequests requests in
+	// the last Window failed at this rate; 0 disables the check.
+	FailureRate  float64
+	MinRequests  int
+	Window       time.Duration
+	OpenDuration time.Duration
+	// HalfOpenRequests is the number of trial requests let through once
+	// OpenDuration passed; all must succeed to close the circuit again.
+	HalfOpenRequests int
+}
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// CircuitStates lists the states in order, e.g. to report each of them.
+var CircuitStates = []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen}
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// breakerState is the circuit breaker of a backend, guarded by the backend's
+// mux. An open circuit becomes half-open lazily, on the first look after
+// openUntil.
+type breakerState struct {
+	state               CircuitState
+	consecutiveFailures int
+	window              slidingWindow
+	openUntil           time.Time
+	openCount           int
+
+	// Half-open bookkeeping, with the limits of the options in force when
+	// the circuit opened.
+	trialLimit    int
+	trialTimeout  time.Duration
+	trials        int
+	successes     int
+	halfOpenSince time.Time
+}
+
+// current returns the state at now without changing it.
+func (s *breakerState) current(now time.Time) CircuitState {
+	if s.state == CircuitOpen && !now.Before(s.openUntil) {
+		return CircuitHalfOpen
+	}
+	return s.state
+}
+
+// blocks reports whether the breaker turns away new requests: the circuit
+// is open, or half-open with all trial requests under way.
+func (s *breakerState) blocks(now time.Time) bool {
+	switch s.current(now) {
+	case CircuitOpen:
+		return true
+	case CircuitHalfOpen:
+		return s.state == CircuitHalfOpen && s.trials >= s.trialLimit && now.Sub(s.halfOpenSince) < s.trialTimeout
+	}
+	return false
+}
+
+// advance applies the transitions that only depend on time. Trials whose
+// result never came back, e.g. because the client went away, are given up
+// after trialTimeout so the circuit cannot stay half-open forever.
+func (s *breakerState) advance(now time.Time) {
+	switch {
+	case s.state == CircuitOpen && !now.Before(s.openUntil),
+		s.state == CircuitHalfOpen && s.trials >= s.trialLimit && now.Sub(s.halfOpenSince) >= s.trialTimeout:
+		s.state = CircuitHalfOpen
+		s.trials = 0
+		s.successes = 0
+		s.halfOpenSince = now
+	}
+}
+
+func (s *breakerState) open(opts BreakerOptions, now time.Time) {
+	s.state = CircuitOpen
+	s.openUntil = now.Add(opts.OpenDuration)
+	s.openCount++
+	s.trialLimit = opts.HalfOpenRequests
+	s.trialTimeout = opts.OpenDuration
+	s.consecutiveFailures = 0
+	s.window = slidingWindow{}
+}
+
+// allowRequest claims a trial slot when the circuit is half-open. Several
+// requests can select a half-open backend at once; only trialLimit of them
+// get through.
+func (b *Backend) allowRequest() bool {
+	return b.allowRequestAt(time.Now())
+}
+
+func (b *Backend) allowRequestAt(now time.Time) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state := &b.breaker
+	state.advance(now)
+	switch state.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if state.trials >= state.trialLimit {
+			return false
+		}
+		state.trials++
+	}
+	return true
+}
+
+// releaseTrial gives back a trial slot claimed by allowRequest for a request
+// that was never sent.
+func (b *Backend) releaseTrial() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state := &b.breaker
+	if state.state == CircuitHalfOpen && state.trials > 0 {
+		state.trials--
+	}
+}
+
+// recordBreakerResult counts a request result and moves the circuit between
+// states, logging every transition.
+func (b *Backend) recordBreakerResult(failed bool, opts BreakerOptions, now time.Time) {
+	b.mux.Lock()
+	state := &b.breaker
+	state.advance(now)
+	from := state.state
+
+	switch state.state {
+	case CircuitClosed:
+		state.window.add(failed, opts.Window, now)
+		if !failed {
+			state.consecutiveFailures = 0
+			break
+		}
+		state.consecutiveFailures++
+
+		total, failures := state.window.counts(opts.Window, now)
+		if (opts.ConsecutiveFailures > 0 && state.consecutiveFailures >= opts.ConsecutiveFailures) ||
+			(opts.FailureRate > 0 && total >= opts.MinRequests && float64(failures)/float64(total) >= opts.FailureRate) {
+			state.open(opts, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			state.open(opts, now)
+			break
+		}
+		state.successes++
+		if state.successes >= state.trialLimit {
+			*state = breakerState{openCount: state.openCount}
+		}
+	}
+	// Results of requests sent before the circuit opened are ignored.
+
+	to := state.state
+	b.mux.Unlock()
+
+	if from == to {
+		return
+	}
+	if to == CircuitOpen {
+		circuitOpensTotal.WithLabelValues(b.URL.String()).Inc()
+		log.Printf("Backend %s circuit opened for %v", b.URL.String(), opts.OpenDuration)
+	} else {
+		log.Printf("Backend %s circuit %s", b.URL.String(), to)
+	}
+}
+
+// resetBreaker closes the circuit, for when the pool's breaker is turned off.
+func (b *Backend) resetBreaker() {
+	b.mux.Lock()
+	b.breaker = breakerState{openCount: b.breaker.openCount}
+	b.mux.Unlock()
+}
+
+func (b *Backend) CircuitState() CircuitState {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.breaker.current(time.Now())
+}
+
+type BreakerStatus struct {
+	State     string     `json:"state"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	OpenCount int        `json:"open_count"`
+}
+
+func (b *Backend) GetBreakerStatus() BreakerStatus {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	now := time.Now()
+	status := BreakerStatus{State: b.breaker.current(now).String(), OpenCount: b.breaker.openCount}
+	if b.breaker.current(now) == CircuitOpen {
+		openUntil := b.breaker.openUntil
+		status.OpenUntil = &openUntil
+	}
+	return status
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerStateMachine(t *testing.T) {
+	consecutive := BreakerOptions{Enabled: true, ConsecutiveFailures: 3, Window: 10 * time.Second, OpenDuration: 5 * time.Second, HalfOpenRequests: 2}
+	rate := BreakerOptions{Enabled: true, FailureRate: 0.5, MinRequests: 4, Window: 10 * time.Second, OpenDuration: 5 * time.Second, HalfOpenRequests: 2}
+
+	type event struct {
+		at time.Duration
+		// do is "fail", "ok" or "allow"; allowed is the expected answer
+		// of "allow".
+		do      string
+		allowed bool
+		want    CircuitState
+	}
+	openAt0 := []event{
+		{0, "fail", false, CircuitClosed},
+		{0, "fail", false, CircuitClosed},
+		{0, "fail", false, CircuitOpen},
+	}
+
+	tests := []struct {
+		name   string
+		opts   BreakerOptions
+		events []event
+	}{
+		{"consecutive failures open", consecutive, append(openAt0[:3:3],
+			event{time.Second, "allow", false, CircuitOpen},
+		)},
+		{"success resets the streak", consecutive, []event{
+			{0, "fail", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+			{0, "ok", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+			{0, "allow", true, CircuitClosed},
+		}},
+		{"failure rate opens", rate, []event{
+			{0, "ok", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+			{0, "ok", false, CircuitClosed},
+			{0, "fail", false, CircuitOpen},
+		}},
+		{"failure rate waits for min requests", rate, []event{
+			{0, "fail", false, CircuitClosed},
+			{0, "ok", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+		}},
+		{"failures slide out of the window", rate, []event{
+			{0, "fail", false, CircuitClosed},
+			{0, "fail", false, CircuitClosed},
+			{11 * time.Second, "ok", false, CircuitClosed},
+			{11 * time.Second, "fail", false, CircuitClosed},
+			{11 * time.Second, "ok", false, CircuitClosed},
+		}},
+		{"results while open are ignored", consecutive, append(openAt0[:3:3],
+			event{time.Second, "ok", false, CircuitOpen},
+			event{4 * time.Second, "allow", false, CircuitOpen},
+		)},
+		{"successful trials close", consecutive, append(openAt0[:3:3],
+			event{5 * time.Second, "allow", true, CircuitHalfOpen},
+			event{5 * time.Second, "allow", true, CircuitHalfOpen},
+			event{5 * time.Second, "allow", false, CircuitHalfOpen},
+			event{6 * time.Second, "ok", false, CircuitHalfOpen},
+			event{6 * time.Second, "ok", false, CircuitClosed},
+			event{6 * time.Second, "allow", true, CircuitClosed},
+		)},
+		{"failed trial reopens", consecutive, append(openAt0[:3:3],
+			event{5 * time.Second, "allow", true, CircuitHalfOpen},
+			event{6 * time.Second, "fail", false, CircuitOpen},
+			event{10 * time.Second, "allow", false, CircuitOpen},
+			event{11 * time.Second, "allow", true, CircuitHalfOpen},
+		)},
+		{"abandoned trials are given up", consecutive, append(openAt0[:3:3],
+			event{5 * time.Second, "allow", true, CircuitHalfOpen},
+			event{5 * time.Second, "allow", true, CircuitHalfOpen},
+			event{9 * time.Second, "allow", false, CircuitHalfOpen},
+			event{10 * time.Second, "allow", true, CircuitHalfOpen},
+		)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestBackend(t, "http://a.test", 1)
+			start := time.Now()
+			for i, e := range tt.events {
+				now := start.Add(e.at)
+				switch e.do {
+				case "fail", "ok":
+					backend.recordBreakerResult(e.do == "fail", tt.opts, now)
+				case "allow":
+					if got := backend.allowRequestAt(now); got != e.allowed {
+						t.Fatalf("event %d: allowed %v, want %v", i, got, e.allowed)
+					}
+				}
+				backend.mux.RLock()
+				got := backend.breaker.current(now)
+				backend.mux.RUnlock()
+				if got != e.want {
+					t.Fatalf("event %d (%s at %v): state %s, want %s", i, e.do, e.at, got, e.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// clientAddress is what ClientIPResolver.Middleware stores per request.
+type clientAddress struct {
+	ip string
+	// trustedPeer is set when the connection comes from a trusted proxy,
+	// whose forwarding headers may be passed on.
+	trustedPeer bool
+}
+
+// ClientIPResolver finds the real client address of a request. Forwarding
+// headers are only believed when they were added by a trusted proxy: the
+// chain is walked right to left and the first untrusted hop is the client.
+// Only the one header the trusted proxies write is read, since a client can
+// send the other one through them untouched.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewClientIPResolver reads the chain from header, "x-forwarded-for" or
+// "forwarded".
+func NewClientIPResolver(trustedProxies []string, header string) (*ClientIPResolver, error) {
+	if header != "x-forwarded-for" && header != "forwarded" {
+		return nil, errors.New("invalid client IP header: " + header)
+	}
+	cr := &ClientIPResolver{header: header}
+
+	for _, entry := range trustedProxies {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy address: " + entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy CIDR: " + entry)
+		}
+		cr.trusted = append(cr.trusted, network)
+	}
+
+	return cr, nil
+}
+
+func (cr *ClientIPResolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range cr.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cr *ClientIPResolver) Resolve(r *http.Request) string {
+	peer := remoteHost(r.RemoteAddr)
+	if !cr.IsTrusted(net.ParseIP(peer)) {
+		return peer
+	}
+
+	var hops []string
+	if cr.header == "forwarded" {
+		hops = parseForwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !cr.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// Middleware resolves the client address once per request and stores it in
+// the request context, where ClientIP reads it.
+func (cr *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address := clientAddress{
+			ip:          cr.Resolve(r),
+			trustedPeer: cr.IsTrusted(net.ParseIP(remoteHost(r.RemoteAddr))),
+		}
+		ctx := context.WithValue(r.Context(), clientIPKey{}, address)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the address resolved by ClientIPResolver.Middleware, or
+// the connection peer when the request did not go through it.
+func ClientIP(r *http.Request) string {
+	if address, ok := r.Context().Value(clientIPKey{}).(clientAddress); ok {
+		return address.ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// fromTrustedProxy reports whether ClientIPResolver.Middleware found the
+// connection peer among the trusted proxies. It is false for requests that
+// did not go through it.
+func fromTrustedProxy(r *http.Request) bool {
+	address, _ := r.Context().Value(clientIPKey{}).(clientAddress)
+	return address.trustedPeer
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// parseForwardedFor extracts the for= parameters of RFC 7239 Forwarded
+// headers in order, stripping quotes, brackets and ports.
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				val = strings.Trim(val, "\"")
+				if strings.HasPrefix(val, "[") {
+					if end := strings.Index(val, "]"); end > 0 {
+						val = val[1:end]
+					}
+				} else if host, _, err := net.SplitHostPort(val); err == nil {
+					val = host
+				}
+				hops = append(hops, val)
+			}
+		}
+	}
+	return hops
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"log"
+	"time"
+)
+
+const drainPollInterval = 100 * time.Millisecond
+
+// drainState is guarded by the backend's mux. A generation counter lets a
+// new drain or an undrain retire the watcher of a previous drain.
+type drainState struct {
+	draining   bool
+	state      string
+	startedAt  time.Time
+	deadline   time.Time
+	finishedAt time.Time
+	generation int
+	// onDone runs when the drain finishes, unless an undrain or a new drain
+	// retired it first.
+	onDone func()
+}
+
+type DrainStatus struct {
+	State              string     `json:"state"`
+	StartedAt          *time.Time `json:"started_at,omitempty"`
+	Deadline           *time.Time `json:"deadline,omitempty"`
+	FinishedAt         *time.Time `json:"finished_at,omitempty"`
+	CurrentConnections int64      `json:"current_connections"`
+}
+
+func (b *Backend) IsDraining() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.drain.draining
+}
+
+func (b *Backend) GetDrainStatus() DrainStatus {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	status := DrainStatus{
+		State:              b.drain.state,
+		CurrentConnections: b.GetCurrentConns(),
+	}
+	if status.State == "" {
+		status.State = "active"
+		return status
+	}
+
+	startedAt, deadline := b.drain.startedAt, b.drain.deadline
+	status.StartedAt = &startedAt
+	status.Deadline = &deadline
+	if !b.drain.finishedAt.IsZero() {
+		finishedAt := b.drain.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// DrainBackend stops sending new requests and sticky assignments to the
+// backend. In-flight requests continue; the state becomes "drained" once
+// they reach zero, or "timed_out" if they are still running at the deadline.
+func (p *ServerPool) DrainBackend(id string, timeout time.Duration) (*Backend, error) {
+	backend := p.GetBackendByID(id)
+	if backend == nil {
+		return nil, ErrBackendNotFound
+	}
+	backend.startDrain(timeout, nil)
+	return backend, nil
+}
+
+// drainAndRemove drains the backend and takes it out of the pool once the
+// drain finishes. Undraining the backend first cancels the removal.
+func (p *ServerPool) drainAndRemove(backend *Backend, timeout time.Duration) {
+	backend.startDrain(timeout, func() {
+		if p.RemoveBackendByID(backend.ID) != nil {
+			log.Printf("Backend %s removed after draining", backend.URL.String())
+		}
+	})
+}
+
+// RemoveBackendAfterDrain drains the backend and removes it from the pool
+// once its in-flight requests finished or the timeout passed. A backend
+// already on its way out keeps its original deadline.
+func (p *ServerPool) RemoveBackendAfterDrain(id string, timeout time.Duration) (*Backend, error) {
+	backend := p.GetBackendByID(id)
+	if backend == nil {
+		return nil, ErrBackendNotFound
+	}
+	if !backend.IsRemoving() {
+		p.drainAndRemove(backend, timeout)
+	}
+	return backend, nil
+}
+
+// IsRemoving reports whether the backend is draining before its removal.
+func (b *Backend) IsRemoving() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.drain.draining && b.drain.onDone != nil
+}
+
+func (b *Backend) startDrain(timeout time.Duration, onDone func()) {
+	now := time.Now()
+	b.mux.Lock()
+	b.drain.generation++
+	generation := b.drain.generation
+	b.drain.draining = true
+	b.drain.state = "draining"
+	b.drain.startedAt = now
+	b.drain.deadline = now.Add(timeout)
+	b.drain.finishedAt = time.Time{}
+	b.drain.onDone = onDone
+	b.mux.Unlock()
+
+	log.Printf("Draining backend %s (%d active connections, timeout %v)", b.URL.String(), b.GetCurrentConns(), timeout)
+	go b.watchDrain(generation)
+}
+
+// UndrainBackend puts a drained or draining backend back into rotation.
+func (p *ServerPool) UndrainBackend(id string) (*Backend, error) {
+	backend := p.GetBackendByID(id)
+	if backend == nil {
+		return nil, ErrBackendNotFound
+	}
+
+	backend.mux.Lock()
+	backend.drain = drainState{generation: backend.drain.generation + 1}
+	backend.mux.Unlock()
+
+	log.Printf("Backend %s is back in rotation", backend.URL.String())
+	return backend, nil
+}
+
+func (b *Backend) watchDrain(generation int) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		conns := b.GetCurrentConns()
+
+		b.mux.Lock()
+		if b.drain.generation != generation {
+			b.mux.Unlock()
+			return
+		}
+
+		now := time.Now()
+		if conns == 0 {
+			b.drain.state = "drained"
+		} else if now.After(b.drain.deadline) {
+			b.drain.state = "timed_out"
+		} else {
+			b.mux.Unlock()
+			continue
+		}
+		b.drain.finishedAt = now
+		state := b.drain.state
+		onDone := b.drain.onDone
+		b.mux.Unlock()
+
+		log.Printf("Drain of backend %s finished: %s (%d active connections)", b.URL.String(), state, conns)
+		if onDone != nil {
+			onDone()
+		}
+		return
+	}
+}
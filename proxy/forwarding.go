@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+type ForwardingOptions struct {
+	// HostHeader is "preserve" to keep the client's Host, "backend" to use
+	// the backend's host, or a literal value sent as is.
+	HostHeader string
+	// XForwarded and Forwarded are "append", "overwrite" or "off".
+	XForwarded   string
+	Forwarded    string
+	XRealIP      bool
+	StripHeaders []string
+}
+
+// applyForwarding rewrites the outbound request. httputil.ReverseProxy has
+// already removed hop-by-hop headers and, because Rewrite is used, the
+// incoming Forwarded and X-Forwarded-* headers from pr.Out.
+func applyForwarding(pr *httputil.ProxyRequest, opts ForwardingOptions) {
+	in := pr.In
+	out := pr.Out
+
+	switch opts.HostHeader {
+	case "", "preserve":
+		out.Host = in.Host
+	case "backend":
+		out.Host = ""
+	default:
+		out.Host = opts.HostHeader
+	}
+
+	clientIP := ClientIP(in)
+	peerIP := remoteHost(in.RemoteAddr)
+	proto := requestProto(in)
+
+	switch opts.XForwarded {
+	case "append", "":
+		forwardedFor := peerIP
+		if prior := in.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			forwardedFor = strings.Join(prior, ", ") + ", " + peerIP
+		}
+		out.Header.Set("X-Forwarded-For", forwardedFor)
+		// Only a trusted proxy may tell the backend the original host and
+		// scheme; anyone else could claim https or any host.
+		forwardedHost, forwardedProto := in.Host, proto
+		if fromTrustedProxy(in) {
+			forwardedHost = firstNonEmpty(in.Header.Get("X-Forwarded-Host"), in.Host)
+			forwardedProto = firstNonEmpty(in.Header.Get("X-Forwarded-Proto"), proto)
+		}
+		out.Header.Set("X-Forwarded-Host", forwardedHost)
+		out.Header.Set("X-Forwarded-Proto", forwardedProto)
+	case "overwrite":
+		out.Header.Set("X-Forwarded-For", clientIP)
+		out.Header.Set("X-Forwarded-Host", in.Host)
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	element := "for=" + forwardedNode(peerIP) + ";host=" + quoteForwarded(in.Host) + ";proto=" + proto
+	switch opts.Forwarded {
+	case "append":
+		if prior := in.Header.Values("Forwarded"); len(prior) > 0 {
+			element = strings.Join(prior, ", ") + ", " + element
+		}
+		out.Header.Set("Forwarded", element)
+	case "overwrite":
+		out.Header.Set("Forwarded", "for="+forwardedNode(clientIP)+";host="+quoteForwarded(in.Host)+";proto="+proto)
+	}
+
+	if opts.XRealIP {
+		out.Header.Set("X-Real-IP", clientIP)
+	} else {
+		out.Header.Del("X-Real-IP")
+	}
+
+	for _, name := range opts.StripHeaders {
+		out.Header.Del(name)
+	}
+}
+
+// forwardedNode formats an address as an RFC 7239 node, quoting and
+// bracketing IPv6 addresses.
+func forwardedNode(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "\"[" + ip + "]\""
+	}
+	return ip
+}
+
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func requestProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
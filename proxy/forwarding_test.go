@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+)
+
+func TestForwardedHostAndProtoFromTrustedProxiesOnly(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8"}, "x-forwarded-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantHost   string
+		wantProto  string
+	}{
+		{"trusted proxy", "10.0.0.1:1234", "shop.example", "https"},
+		{"direct client", "203.0.113.5:1234", "proxy.test", "http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out *http.Request
+			handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, in *http.Request) {
+				out = in.Clone(in.Context())
+				// Rewrite hands over an outbound request without them.
+				out.Header.Del("X-Forwarded-Host")
+				out.Header.Del("X-Forwarded-Proto")
+				applyForwarding(&httputil.ProxyRequest{In: in, Out: out}, ForwardingOptions{XForwarded: "append"})
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.test/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-Host", "shop.example")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got := out.Header.Get("X-Forwarded-Host"); got != tt.wantHost {
+				t.Errorf("X-Forwarded-Host %q, want %q", got, tt.wantHost)
+			}
+			if got := out.Header.Get("X-Forwarded-Proto"); got != tt.wantProto {
+				t.Errorf("X-Forwarded-Proto %q, want %q", got, tt.wantProto)
+			}
+		})
+	}
+}
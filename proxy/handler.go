@@ -1,52 +1,329 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"net/http/httputil"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"reverseproxy.com/tracing"
 )
 
-func ProxyHandler(pool LoadBalancer, timeout time.Duration, stickyEnabled bool, strategy string) http.HandlerFunc {
+type HandlerOptions struct {
+	Timeout       time.Duration
+	StickyEnabled bool
+	Strategy      string
+	// HashKey selects the consistent-hash key: "client-ip", "path",
+	// "header:<name>" or "cookie:<name>".
+	HashKey    string
+	Forwarding ForwardingOptions
+	// Route labels the request metrics; empty for the default pool.
+	Route string
+	Retry RetryOptions
+}
+
+func ProxyHandler(pool LoadBalancer, opts HandlerOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+		info := requestInfo(r)
+		info.Route = opts.Route
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			span.SetAttribute("proxy.route", opts.Route)
+			span.SetAttribute("client.address", ClientIP(r))
+			if id := RequestID(r); id != "" {
+				span.SetAttribute("proxy.request_id", id)
+			}
+		}
+
 		var backend *Backend
+		stickyPool, _ := pool.(*StickySessionPool)
 
-		if stickyEnabled {
-			if stickyPool, ok := pool.(*StickySessionPool); ok {
-				backend = stickyPool.GetBackendForClient(r)
+		_, selectSpan := tracing.Start(r.Context(), "select backend", tracing.SpanKindInternal)
+		selectSpan.SetAttribute("proxy.strategy", opts.Strategy)
+		selectSpan.SetAttribute("proxy.sticky", opts.StickyEnabled)
+		if opts.StickyEnabled {
+			if stickyPool != nil {
+				backend = stickyPool.GetBackendForClient(w, r)
 			} else {
-				log.Println("Warning: stickyEnabled is true but pool is not a StickySessionPool")
+				log.Println(logPrefix(r) + "Warning: stickyEnabled is true but pool is not a StickySessionPool")
 				backend = pool.GetNextValidPeer()
 			}
 		} else {
-			if strategy == "least-conn" {
-				backend = pool.GetLeastConnBackend()
-			} else {
-				backend = pool.GetNextValidPeer()
-			}
+			backend = selectBackend(pool, r, opts)
 		}
+		backend = admit(pool, backend, nil)
 
 		if backend == nil {
-			http.Error(w, "503 Service unavailable", http.StatusServiceUnavailable)
+			selectSpan.SetError("no backend available")
+			selectSpan.End()
+			WriteError(w, r, "503 Service unavailable", http.StatusServiceUnavailable)
+			observeRequest(opts.Route, "", http.StatusServiceUnavailable, time.Since(received))
 			return
 		}
 
-		backend.IncrementConnections()
-		defer backend.DecrementConnections()
+		info.Backend = backend.URL.String()
+		selectSpan.SetAttribute("proxy.backend", info.Backend)
+		selectSpan.End()
 
-		proxy := httputil.NewSingleHostReverseProxy(backend.URL)
-
-		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout)
 		defer cancel()
+
+		pr := &proxyRequest{
+			pool:       pool,
+			stickyPool: stickyPool,
+			opts:       opts,
+			info:       info,
+			ctx:        ctx,
+		}
+		opts.Retry.Budget.recordRequest()
+		if opts.Retry.MaxRetries > 0 && (isIdempotent(r.Method) || opts.Retry.NonIdempotent) {
+			pr.body, pr.retryable = replayableBody(r, opts.Retry.MaxBodyBytes)
+		}
 		r = r.WithContext(ctx)
 
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Println("Backend ", backend.URL.String(), " failed: ", err)
-			backend.SetAlive(false)
-			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		for {
+			next := pr.try(w, r, backend)
+			if next == nil {
+				break
+			}
+
+			info.Retries++
+			if !sleepContext(ctx, opts.Retry.backoff(info.Retries)) {
+				// next was admitted but is not tried, so its half-open
+				// trial slot goes back.
+				next.releaseTrial()
+				log.Printf("%sGave up retrying for client %s: %v", logPrefix(r), ClientIP(r), ctx.Err())
+				pr.status = http.StatusBadGateway
+				WriteError(w, r, "502 Bad Gateway", http.StatusBadGateway)
+				break
+			}
+			backend = next
+			info.Backend = backend.URL.String()
+		}
+
+		observeRequest(opts.Route, backend.URL.String(), pr.status, time.Since(received))
+	}
+}
+
+// selectBackend picks a backend with the configured strategy.
+func selectBackend(pool LoadBalancer, r *http.Request, opts HandlerOptions) *Backend {
+	switch opts.Strategy {
+	case "least-conn":
+		return pool.GetLeastConnBackend()
+	case "consistent-hash":
+		return pool.GetConsistentHashBackend(requestHashKey(r, opts.HashKey))
+	case "p2c-ewma":
+		return pool.GetP2CEWMABackend()
+	default:
+		return pool.GetNextValidPeer()
+	}
+}
+
+// proxyRequest is the state of one client request across its attempts.
+type proxyRequest struct {
+	pool       LoadBalancer
+	stickyPool *StickySessionPool
+	opts       HandlerOptions
+	info       *RequestInfo
+	// ctx bounds the whole request, retries included.
+	ctx context.Context
+
+	// retryable is set when the method allows retries and the body, if
+	// any, was buffered into body for replaying.
+	retryable bool
+	body      []byte
+	tried     []*Backend
+	status    int
+}
+
+// try sends the request to backend once. It returns the backend for the next
+// attempt when this one failed in a retryable way, in which case nothing was
+// written to w.
+func (pr *proxyRequest) try(w http.ResponseWriter, r *http.Request, backend *Backend) *Backend {
+	pr.tried = append(pr.tried, backend)
+	backend.IncrementConnections()
+	defer backend.DecrementConnections()
+
+	ctx, upstreamSpan := tracing.Start(r.Context(), "upstream", tracing.SpanKindClient)
+	upstreamSpan.SetAttribute("http.request.method", r.Method)
+	upstreamSpan.SetAttribute("url.full", backend.URL.JoinPath(r.URL.Path).String())
+	upstreamSpan.SetAttribute("server.address", backend.URL.Host)
+	upstreamSpan.SetAttribute("proxy.backend", backend.URL.String())
+	upstreamSpan.SetAttribute("proxy.strategy", pr.opts.Strategy)
+	upstreamSpan.SetAttribute("proxy.retry_count", len(pr.tried)-1)
+	defer upstreamSpan.End()
+
+	// The per-try timeout only covers the wait for the response headers, so
+	// it does not cut off a long download once the backend has answered.
+	ctx, cancelTry := context.WithCancel(ctx)
+	defer cancelTry()
+	var timedOut atomic.Bool
+	stopTimer := func() bool { return true }
+	if pr.opts.Retry.PerTryTimeout > 0 {
+		timer := time.AfterFunc(pr.opts.Retry.PerTryTimeout, func() {
+			timedOut.Store(true)
+			cancelTry()
+		})
+		defer timer.Stop()
+		stopTimer = timer.Stop
+	}
+
+	r = r.WithContext(ctx)
+	if pr.body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(pr.body))
+		r.ContentLength = int64(len(pr.body))
+	}
+
+	start := time.Now()
+	var next *Backend
+	attempt := &proxyAttempt{
+		forwarding: pr.opts.Forwarding,
+		headers:    make(http.Header),
+		modifyResponse: func(resp *http.Response) error {
+			stopTimer()
+			upstreamSpan.SetAttribute("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode >= 500 {
+				upstreamSpan.SetError(resp.Status)
+			}
+			pr.info.UpstreamLatency = time.Since(start)
+			backend.ObserveLatency(pr.info.UpstreamLatency)
+			pr.pool.RecordResult(backend, resp.StatusCode, nil)
+
+			if pr.opts.Retry.retriesStatus(resp.StatusCode) {
+				if next = pr.retryBackend(r, strconv.Itoa(resp.StatusCode)); next != nil {
+					log.Printf("%sBackend %s returned %s for client %s, retrying on %s", logPrefix(r), backend.URL.String(), resp.Status, ClientIP(r), next.URL.String())
+					return errRetry
+				}
+			}
+
+			pr.status = resp.StatusCode
+			// The ID was set on the response already; drop the
+			// backend's echo so it is not sent twice.
+			if rid := requestIDFrom(resp.Request.Context()); rid.header != "" {
+				resp.Header.Del(rid.header)
+			}
+			if pr.stickyPool != nil {
+				pr.stickyPool.ObserveResponse(resp, backend)
+			}
+			return nil
+		},
+		errorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, errRetry) {
+				return
+			}
+
+			upstreamSpan.SetError(err.Error())
+			pr.info.UpstreamLatency = time.Since(start)
+			backend.ObserveLatency(pr.info.UpstreamLatency)
+			// A client that went away says nothing about the backend.
+			if !errors.Is(pr.ctx.Err(), context.Canceled) {
+				pr.pool.RecordResult(backend, 0, err)
+			}
+
+			if reason := pr.opts.Retry.retryReason(err, timedOut.Load()); reason != "" {
+				if next = pr.retryBackend(r, reason); next != nil {
+					log.Printf("%sBackend %s failed for client %s: %v, retrying on %s", logPrefix(r), backend.URL.String(), ClientIP(r), err, next.URL.String())
+					return
+				}
+			}
+
+			log.Printf("%sBackend %s failed for client %s: %v", logPrefix(r), backend.URL.String(), ClientIP(r), err)
+			pr.status = http.StatusBadGateway
+			WriteError(w, r, "502 Bad Gateway", http.StatusBadGateway)
+		},
+	}
+
+	if upstreamSpan != nil {
+		tracing.Inject(upstreamSpan.Context(), attempt.headers)
+		// Replace an invalid incoming tracestate instead of passing it on.
+		if _, ok := attempt.headers["Tracestate"]; !ok {
+			attempt.headers["Tracestate"] = nil
 		}
+	}
+
+	backend.ReverseProxy().ServeHTTP(w, withAttempt(r, attempt))
+	return next
+}
 
-		proxy.ServeHTTP(w, r)
+// retryBackend picks the backend for another attempt, or returns nil when
+// the request may not be retried. The strategy chooses first; if it picks a
+// backend that was already tried, as consistent hashing will, the least
+// loaded untried one is used instead.
+func (pr *proxyRequest) retryBackend(r *http.Request, reason string) *Backend {
+	if !pr.retryable || len(pr.tried) > pr.opts.Retry.MaxRetries || pr.ctx.Err() != nil {
+		return nil
+	}
+
+	backend := selectBackend(pr.pool, r, pr.opts)
+	if backend == nil || slices.Contains(pr.tried, backend) {
+		backend = pr.pool.GetAlternateBackend(pr.tried)
+	}
+	if backend == nil {
+		return nil
+	}
+
+	// The budget goes first: a claimed half-open trial slot that is not
+	// used stays blocked until the breaker gives up on it.
+	if !pr.opts.Retry.Budget.withdraw() {
+		retryBudgetExhaustedTotal.WithLabelValues(pr.opts.Route).Inc()
+		return nil
+	}
+	if backend = admit(pr.pool, backend, pr.tried); backend == nil {
+		pr.opts.Retry.Budget.refund()
+		return nil
+	}
+	retriesTotal.WithLabelValues(pr.opts.Route, reason).Inc()
+	return backend
+}
+
+// admit returns backend if its circuit breaker lets the request through,
+// and otherwise the least loaded other backend that does. A half-open
+// circuit only admits a few trial requests, which concurrent requests may
+// have claimed since the backend was selected.
+func admit(pool LoadBalancer, backend *Backend, exclude []*Backend) *Backend {
+	for backend != nil && !backend.allowRequest() {
+		exclude = append(slices.Clip(exclude), backend)
+		backend = pool.GetAlternateBackend(exclude)
+	}
+	return backend
+}
+
+// sleepContext waits for d and reports false if ctx ended first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requestHashKey extracts the consistent-hash key from the request, falling
+// back to the client IP when the configured header or cookie is missing.
+func requestHashKey(r *http.Request, hashKey string) string {
+	switch {
+	case hashKey == "path":
+		return r.URL.Path
+	case strings.HasPrefix(hashKey, "header:"):
+		if value := r.Header.Get(strings.TrimPrefix(hashKey, "header:")); value != "" {
+			return value
+		}
+	case strings.HasPrefix(hashKey, "cookie:"):
+		if cookie, err := r.Cookie(strings.TrimPrefix(hashKey, "cookie:")); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
 	}
-}
\ No newline at end of file
+	return ClientIP(r)
+}
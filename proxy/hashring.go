@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+const defaultVirtualNodes = 100
+
+type hashRing struct {
+	hashes []uint64
+	// owners holds the index in members of the backend owning each point.
+	owners  []int32
+	members []*Backend
+}
+
+// newHashRing places virtualNodes points per unit of weight on the ring for
+// every backend, so heavier backends own a proportionally larger key space.
+func newHashRing(backends []*Backend, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	type point struct {
+		hash  uint64
+		owner int32
+	}
+	var points []point
+
+	for i, backend := range backends {
+		weight := backend.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for j := 0; j < virtualNodes*weight; j++ {
+			points = append(points, point{
+				hash:  hashKey(backend.URL.String() + "#" + strconv.Itoa(j)),
+				owner: int32(i),
+			})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	ring := &hashRing{
+		hashes:  make([]uint64, len(points)),
+		owners:  make([]int32, len(points)),
+		members: append([]*Backend(nil), backends...),
+	}
+	for i, pt := range points {
+		ring.hashes[i] = pt.hash
+		ring.owners[i] = pt.owner
+	}
+	return ring
+}
+
+// hashKey is FNV-1a, inlined so hashing a request key does not allocate.
+func hashKey(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
+
+// backendSet is a bitset over the members of a ring.
+type backendSet []uint64
+
+// newBackendSet returns a set of n members in buf, which the caller keeps on
+// its stack, or a new allocation when buf is too small.
+func newBackendSet(buf []uint64, n int) backendSet {
+	words := (n + 63) / 64
+	if words > len(buf) {
+		return make(backendSet, words)
+	}
+	return buf[:words]
+}
+
+func (s backendSet) has(i int32) bool {
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s backendSet) add(i int32) {
+	s[i/64] |= 1 << (i % 64)
+}
+
+// get walks the ring clockwise from the key and returns the first live backend.
+// With a loadFactor >= 1 a backend is skipped once its connections exceed
+// loadFactor times the average load ("consistent hashing with bounded loads").
+// Each backend is checked at most once, so a lookup costs O(log points) for
+// the search plus O(backends) for the walk.
+func (ring *hashRing) get(key string, loadFactor float64) *Backend {
+	if len(ring.hashes) == 0 {
+		return nil
+	}
+
+	// With bounded loads every member is checked up front for the average,
+	// and unavailable remembers the result for the walk.
+	bounded := loadFactor >= 1
+	var unavailableBuf, visitedBuf [4]uint64
+	var unavailable backendSet
+	capacity := int64(math.MaxInt64)
+	if bounded {
+		unavailable = newBackendSet(unavailableBuf[:], len(ring.members))
+		var totalConns int64
+		live := 0
+		for i, backend := range ring.members {
+			if !backend.IsAvailable() {
+				unavailable.add(int32(i))
+				continue
+			}
+			live++
+			totalConns += backend.GetCurrentConns()
+		}
+		if live == 0 {
+			return nil
+		}
+		capacity = int64(math.Ceil(loadFactor * float64(totalConns+1) / float64(live)))
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= h
+	})
+
+	visited := newBackendSet(visitedBuf[:], len(ring.members))
+	remaining := len(ring.members)
+
+	var firstLive *Backend
+	for i := 0; i < len(ring.hashes) && remaining > 0; i++ {
+		owner := ring.owners[(start+i)%len(ring.hashes)]
+		if visited.has(owner) {
+			continue
+		}
+		visited.add(owner)
+		remaining--
+
+		backend := ring.members[owner]
+		if bounded && unavailable.has(owner) || !bounded && !backend.IsAvailable() {
+			continue
+		}
+		if firstLive == nil {
+			firstLive = backend
+		}
+		if backend.GetCurrentConns() < capacity {
+			return backend
+		}
+	}
+
+	return firstLive
+}
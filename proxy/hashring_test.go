@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newTestRing(t *testing.T, n int) ([]*Backend, *hashRing) {
+	t.Helper()
+	backends := make([]*Backend, n)
+	for i := range backends {
+		backends[i] = newTestBackend(t, "http://backend"+strconv.Itoa(i)+".test", 1)
+	}
+	return backends, newHashRing(backends, defaultVirtualNodes)
+}
+
+func TestHashRingSkipsUnavailableBackends(t *testing.T) {
+	backends, ring := newTestRing(t, 5)
+
+	for _, loadFactor := range []float64{0, 1.25} {
+		owner := ring.get("client-42", loadFactor)
+		if owner == nil {
+			t.Fatal("no backend for key")
+		}
+		owner.SetAlive(false)
+		next := ring.get("client-42", loadFactor)
+		if next == nil || next == owner {
+			t.Fatalf("load factor %v: got %v after the owner went down", loadFactor, next)
+		}
+		owner.SetAlive(true)
+		if again := ring.get("client-42", loadFactor); again != owner {
+			t.Fatalf("load factor %v: key moved to %s after the owner recovered", loadFactor, again.URL)
+		}
+	}
+
+	for _, backend := range backends {
+		backend.SetAlive(false)
+	}
+	if backend := ring.get("client-42", 0); backend != nil {
+		t.Fatalf("got %s with every backend down", backend.URL)
+	}
+	if backend := ring.get("client-42", 1.25); backend != nil {
+		t.Fatalf("got %s with every backend down and bounded loads", backend.URL)
+	}
+}
+
+func TestHashRingBoundedLoad(t *testing.T) {
+	_, ring := newTestRing(t, 4)
+
+	owner := ring.get("hot-key", 1.25)
+	for i := 0; i < 10; i++ {
+		owner.IncrementConnections()
+	}
+	if next := ring.get("hot-key", 1.25); next == owner {
+		t.Fatalf("overloaded backend %s still chosen", owner.URL)
+	}
+	if next := ring.get("hot-key", 0); next != owner {
+		t.Fatalf("unbounded lookup moved the key to %s", next.URL)
+	}
+}
+
+func TestHashRingGetDoesNotAllocate(t *testing.T) {
+	_, ring := newTestRing(t, 50)
+
+	for _, loadFactor := range []float64{0, 1.25} {
+		allocs := testing.AllocsPerRun(100, func() {
+			ring.get("client-42", loadFactor)
+		})
+		if allocs != 0 {
+			t.Errorf("load factor %v: %v allocations per lookup, want 0", loadFactor, allocs)
+		}
+	}
+}
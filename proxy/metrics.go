@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"reverseproxy.com/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("proxy_requests_total",
+		"Requests handled by the proxy, by route, backend and status code.",
+		"route", "backend", "code")
+	requestDuration = metrics.NewHistogramVec("proxy_request_duration_seconds",
+		"Time from receiving a request to finishing its response, by route and backend.",
+		metrics.DefaultBuckets, "route", "backend")
+	ejectionsTotal = metrics.NewCounterVec("proxy_backend_ejections_total",
+		"Outlier ejections of a backend.",
+		"backend")
+	circuitOpensTotal = metrics.NewCounterVec("proxy_circuit_breaker_opens_total",
+		"Times the circuit breaker of a backend opened.",
+		"backend")
+	retriesTotal = metrics.NewCounterVec("proxy_retries_total",
+		"Requests retried on another backend, by route and reason.",
+		"route", "reason")
+	retryBudgetExhaustedTotal = metrics.NewCounterVec("proxy_retry_budget_exhausted_total",
+		"Retries skipped because the pool's retry budget was spent.",
+		"route")
+)
+
+func init() {
+	metrics.Default.MustRegister(requestsTotal, requestDuration, ejectionsTotal, circuitOpensTotal, retriesTotal, retryBudgetExhaustedTotal)
+}
+
+// observeRequest records a finished request. backend is empty when no
+// backend was available.
+func observeRequest(route, backend string, code int, elapsed time.Duration) {
+	requestsTotal.WithLabelValues(route, backend, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(route, backend).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"log"
+	"time"
+)
+
+const outlierBuckets = 10
+
+type OutlierOptions struct {
+	Enabled            bool
+	ConsecutiveErrors  int
+	ErrorRate          float64
+	MinRequests        int
+	Window             time.Duration
+	BaseEjectionTime   time.Duration
+	MaxEjectionTime    time.Duration
+	MaxEjectionPercent int
+}
+
+type outlierBucket struct {
+	// n numbers the bucket since the Unix epoch, in units of the bucket size.
+	n        int64
+	total    int
+	failures int
+}
+
+// slidingWindow counts request results over a window as a ring of buckets
+// each covering window/outlierBuckets.
+type slidingWindow [outlierBuckets]outlierBucket
+
+// bucketNumber returns the bucket now falls in. The size is clamped so a
+// window shorter than outlierBuckets nanoseconds does not divide by zero.
+func bucketNumber(window time.Duration, now time.Time) int64 {
+	return now.UnixNano() / int64(max(window/outlierBuckets, 1))
+}
+
+func (w *slidingWindow) add(failed bool, window time.Duration, now time.Time) {
+	n := bucketNumber(window, now)
+	bucket := &w[n%outlierBuckets]
+	if bucket.n != n {
+		*bucket = outlierBucket{n: n}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+}
+
+func (w *slidingWindow) counts(window time.Duration, now time.Time) (total, failures int) {
+	n := bucketNumber(window, now)
+	for _, bucket := range w {
+		// Buckets of another window size fall outside the range.
+		if age := n - bucket.n; age >= 0 && age < outlierBuckets {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+// outlierState is the passive health record of a backend, guarded by the
+// backend's mux.
+type outlierState struct {
+	consecutiveFailures int
+	buckets             slidingWindow
+	ejectionCount       int
+	ejectedUntil        time.Time
+}
+
+func (b *Backend) IsEjected() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Now().Before(b.outlier.ejectedUntil)
+}
+
+// IsAvailable reports whether the backend may receive new requests: it is
+// alive, enabled, still in its pool, not ejected as an outlier, not cut off
+// by its circuit breaker, not draining and below its connection limit.
+func (b *Backend) IsAvailable() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	if !b.Alive || b.disabled || b.removed || b.drain.draining {
+		return false
+	}
+	now := time.Now()
+	if now.Before(b.outlier.ejectedUntil) || b.breaker.blocks(now) {
+		return false
+	}
+	return b.MaxConns <= 0 || b.GetCurrentConns() < b.MaxConns
+}
+
+type OutlierStatus struct {
+	Ejected       bool       `json:"ejected"`
+	EjectedUntil  *time.Time `json:"ejected_until,omitempty"`
+	EjectionCount int        `json:"ejection_count"`
+}
+
+func (b *Backend) GetOutlierStatus() OutlierStatus {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	status := OutlierStatus{EjectionCount: b.outlier.ejectionCount}
+	if time.Now().Before(b.outlier.ejectedUntil) {
+		status.Ejected = true
+		ejectedUntil := b.outlier.ejectedUntil
+		status.EjectedUntil = &ejectedUntil
+	}
+	return status
+}
+
+// recordOutcome adds a request result to the window and reports whether the
+// backend crossed the consecutive-error or error-rate threshold.
+func (b *Backend) recordOutcome(failed bool, opts OutlierOptions, now time.Time) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state := &b.outlier
+
+	// Forget earlier ejections once the backend behaved for a full
+	// MaxEjectionTime, so the backoff starts from BaseEjectionTime again.
+	if state.ejectionCount > 0 && now.Sub(state.ejectedUntil) > opts.MaxEjectionTime {
+		state.ejectionCount = 0
+	}
+
+	state.buckets.add(failed, opts.Window, now)
+
+	if !failed {
+		state.consecutiveFailures = 0
+		return false
+	}
+	state.consecutiveFailures++
+
+	if opts.ConsecutiveErrors > 0 && state.consecutiveFailures >= opts.ConsecutiveErrors {
+		return true
+	}
+
+	total, failures := state.buckets.counts(opts.Window, now)
+	return opts.ErrorRate > 0 && total >= opts.MinRequests && float64(failures)/float64(total) >= opts.ErrorRate
+}
+
+// eject removes the backend from rotation for BaseEjectionTime doubled on
+// every repeated ejection, capped at MaxEjectionTime.
+func (b *Backend) eject(opts OutlierOptions, now time.Time) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state := &b.outlier
+	duration := opts.BaseEjectionTime
+	for i := 0; i < state.ejectionCount && duration < opts.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	if duration > opts.MaxEjectionTime {
+		duration = opts.MaxEjectionTime
+	}
+
+	state.ejectionCount++
+	state.ejectedUntil = now.Add(duration)
+	state.consecutiveFailures = 0
+	state.buckets = slidingWindow{}
+	return duration
+}
+
+// RecordResult feeds the outcome of a proxied request to the circuit breaker
+// and passive health checking. Without either, a transport error marks the
+// backend down until the active health checker sees it again.
+func (p *ServerPool) RecordResult(backend *Backend, statusCode int, err error) {
+	now := time.Now()
+	failed := err != nil || statusCode >= 500
+	if p.Breaker.Enabled {
+		backend.recordBreakerResult(failed, p.Breaker, now)
+	}
+
+	if !p.Outlier.Enabled {
+		if err != nil && !p.Breaker.Enabled {
+			backend.SetAlive(false)
+		}
+		return
+	}
+
+	if !backend.recordOutcome(failed, p.Outlier, now) {
+		return
+	}
+
+	p.Mux.Lock()
+	defer p.Mux.Unlock()
+
+	if backend.IsEjected() {
+		return
+	}
+
+	ejected := 0
+	for _, b := range p.Backends {
+		if b.IsEjected() {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > p.Outlier.MaxEjectionPercent*len(p.Backends) {
+		log.Printf("Backend %s is an outlier but %d%% of the pool is already ejected", backend.URL.String(), p.Outlier.MaxEjectionPercent)
+		return
+	}
+
+	duration := backend.eject(p.Outlier, now)
+	ejectionsTotal.WithLabelValues(backend.URL.String()).Inc()
+	log.Printf("Backend %s ejected for %v", backend.URL.String(), duration)
+}
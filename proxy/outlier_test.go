@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowTinyWindow(t *testing.T) {
+	var w slidingWindow
+	now := time.Now()
+	for _, window := range []time.Duration{0, 1, 9 * time.Nanosecond} {
+		w.add(true, window, now)
+	}
+	if total, failures := w.counts(1, now); total != 3 || failures != 3 {
+		t.Fatalf("got %d requests and %d failures, want 3 and 3", total, failures)
+	}
+}
+
+func TestSlidingWindowBucketKeepsCountsForItsSlot(t *testing.T) {
+	// 700ms buckets do not divide the offset between the Unix epoch and
+	// Go's zero time, so a time-based bucket start would reset mid-slot.
+	const window = 7 * time.Second
+	start := time.Unix(0, 0).Add(1000 * 700 * time.Millisecond)
+
+	var w slidingWindow
+	for _, offset := range []time.Duration{0, 300 * time.Millisecond, 600 * time.Millisecond, 699 * time.Millisecond} {
+		w.add(true, window, start.Add(offset))
+	}
+	if total, failures := w.counts(window, start.Add(699*time.Millisecond)); total != 4 || failures != 4 {
+		t.Fatalf("got %d requests and %d failures, want 4 and 4", total, failures)
+	}
+}
+
+func TestSlidingWindowRollover(t *testing.T) {
+	const window = 7 * time.Second
+	const bucket = window / outlierBuckets
+	start := time.Unix(1700000000, 0)
+
+	var w slidingWindow
+	for i := 0; i < outlierBuckets; i++ {
+		w.add(i%2 == 0, window, start.Add(time.Duration(i)*bucket))
+	}
+	last := start.Add((outlierBuckets - 1) * bucket)
+	if total, failures := w.counts(window, last); total != 10 || failures != 5 {
+		t.Fatalf("full window: got %d requests and %d failures, want 10 and 5", total, failures)
+	}
+
+	// One bucket later the first one, a failure, falls out of the window.
+	if total, failures := w.counts(window, last.Add(bucket)); total != 9 || failures != 4 {
+		t.Fatalf("after one bucket: got %d requests and %d failures, want 9 and 4", total, failures)
+	}
+	// Its slot is reused by the new bucket without carrying the old counts.
+	w.add(false, window, last.Add(bucket))
+	if total, failures := w.counts(window, last.Add(bucket)); total != 10 || failures != 4 {
+		t.Fatalf("after reuse: got %d requests and %d failures, want 10 and 4", total, failures)
+	}
+
+	if total, _ := w.counts(window, last.Add(window+bucket)); total != 0 {
+		t.Fatalf("a window later: got %d requests, want 0", total)
+	}
+}
+
+func newOutlierPool(t *testing.T, n int, opts OutlierOptions) *ServerPool {
+	t.Helper()
+	pool := &ServerPool{Outlier: opts}
+	for i := 0; i < n; i++ {
+		pool.AddBackend(newTestBackend(t, "http://backend-"+string(rune('a'+i))+".test", 1))
+	}
+	return pool
+}
+
+func TestOutlierEjectionAndReadmission(t *testing.T) {
+	pool := newOutlierPool(t, 2, OutlierOptions{
+		Enabled:            true,
+		ConsecutiveErrors:  3,
+		Window:             time.Second,
+		BaseEjectionTime:   50 * time.Millisecond,
+		MaxEjectionTime:    time.Second,
+		MaxEjectionPercent: 50,
+	})
+	backend := pool.Backends[0]
+
+	for i := 0; i < 2; i++ {
+		pool.RecordResult(backend, 502, nil)
+	}
+	pool.RecordResult(backend, 200, nil)
+	pool.RecordResult(backend, 502, nil)
+	if backend.IsEjected() {
+		t.Fatal("ejected although a success broke the run of errors")
+	}
+
+	pool.RecordResult(backend, 503, nil)
+	pool.RecordResult(backend, 503, nil)
+	if !backend.IsEjected() || backend.IsAvailable() {
+		t.Fatal("not ejected after 3 consecutive errors")
+	}
+	if got := pool.GetNextValidPeer(); got != pool.Backends[1] {
+		t.Fatalf("picked %v while backend a is ejected", got.URL)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if backend.IsEjected() || !backend.IsAvailable() {
+		t.Fatal("still ejected after base_ejection_time")
+	}
+	if status := backend.GetOutlierStatus(); status.EjectionCount != 1 {
+		t.Fatalf("ejection count %d, want 1", status.EjectionCount)
+	}
+}
+
+func TestOutlierErrorRate(t *testing.T) {
+	pool := newOutlierPool(t, 2, OutlierOptions{
+		Enabled:            true,
+		ErrorRate:          0.5,
+		MinRequests:        4,
+		Window:             time.Minute,
+		BaseEjectionTime:   time.Minute,
+		MaxEjectionTime:    time.Minute,
+		MaxEjectionPercent: 100,
+	})
+	backend := pool.Backends[0]
+
+	// Failures alternate with successes, so only the rate can eject.
+	for _, status := range []int{500, 200, 500} {
+		pool.RecordResult(backend, status, nil)
+	}
+	if backend.IsEjected() {
+		t.Fatal("ejected below min_requests")
+	}
+	pool.RecordResult(backend, 200, nil)
+	pool.RecordResult(backend, 500, nil)
+	if !backend.IsEjected() {
+		t.Fatal("not ejected at a 60% error rate")
+	}
+}
+
+func TestOutlierEjectionBackoff(t *testing.T) {
+	opts := OutlierOptions{BaseEjectionTime: time.Second, MaxEjectionTime: 10 * time.Second}
+	backend := newTestBackend(t, "http://a.test", 1)
+	now := time.Now()
+
+	for i, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if got := backend.eject(opts, now); got != want*time.Second {
+			t.Fatalf("ejection %d lasted %v, want %v", i+1, got, want*time.Second)
+		}
+	}
+
+	// A backend that behaved for max_ejection_time starts from the base again.
+	later := now.Add(opts.MaxEjectionTime + opts.MaxEjectionTime + time.Second)
+	backend.recordOutcome(false, opts, later)
+	if got := backend.eject(opts, later); got != opts.BaseEjectionTime {
+		t.Fatalf("ejection after a quiet period lasted %v, want %v", got, opts.BaseEjectionTime)
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	pool := newOutlierPool(t, 4, OutlierOptions{
+		Enabled:            true,
+		ConsecutiveErrors:  1,
+		Window:             time.Second,
+		BaseEjectionTime:   time.Minute,
+		MaxEjectionTime:    time.Minute,
+		MaxEjectionPercent: 50,
+	})
+
+	for _, backend := range pool.Backends {
+		pool.RecordResult(backend, 500, nil)
+	}
+
+	ejected := 0
+	for _, backend := range pool.Backends {
+		if backend.IsEjected() {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Fatalf("%d of 4 backends ejected, want 2 with max_ejection_percent 50", ejected)
+	}
+}
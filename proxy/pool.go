@@ -1,55 +1,71 @@
 package proxy
 
 import (
+	"errors"
+	"math/rand"
 	"net/url"
+	"slices"
 	"sync"
-	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrBackendNotFound = errors.New("backend not found")
+	ErrBackendExists   = errors.New("backend already exists")
 )
 
 type ServerPool struct {
-	Backends      []*Backend `json:"backends"`
-	Current       uint64     `json:"current"`
-	Mux           sync.RWMutex
-	CurrentWeight int
-	MaxWeight     int
-	GCD           int
+	Backends         []*Backend `json:"backends"`
+	Mux              sync.RWMutex
+	HashVirtualNodes int
+	HashLoadFactor   float64
+	Transport        TransportOptions
+	Outlier          OutlierOptions
+	Breaker          BreakerOptions
+	SlowStart        SlowStartOptions
+	ring             *hashRing
 }
 
-func gcd(a, b int) int {
-	for b != 0 {
-		a, b = b, a%b
-	}
-	return a
-}
+// GetNextValidPeer implements nginx's smooth weighted round-robin: every live
+// backend gains its weight on each pick, the highest current weight wins and
+// is lowered by the total, which interleaves picks in proportion to weights.
+func (p *ServerPool) GetNextValidPeer() *Backend {
+	p.Mux.Lock()
+	defer p.Mux.Unlock()
 
-func (p *ServerPool) calculateGCD() int {
 	if len(p.Backends) == 0 {
-		return 1
+		return nil
 	}
 
-	result := p.Backends[0].Weight
-	for i := 1; i < len(p.Backends); i++ {
-		result = gcd(result, p.Backends[i].Weight)
-	}
-	return result
-}
+	now := time.Now()
+	totalWeight := 0
+	var bestBackend *Backend
 
-func (p *ServerPool) calculateMaxWeight() int {
-	max := 0
 	for _, backend := range p.Backends {
-		if backend.Weight > max {
-			max = backend.Weight
+		if !backend.IsAvailable() {
+			backend.currentWeight = 0
+			continue
+		}
+
+		weight := p.effectiveWeight(backend, now)
+
+		backend.currentWeight += weight
+		totalWeight += weight
+
+		if bestBackend == nil || backend.currentWeight > bestBackend.currentWeight {
+			bestBackend = backend
 		}
 	}
-	return max
-}
 
-func (p *ServerPool) updateWeightMetrics() {
-	p.GCD = p.calculateGCD()
-	p.MaxWeight = p.calculateMaxWeight()
+	if bestBackend == nil {
+		return nil
+	}
+
+	bestBackend.currentWeight -= totalWeight
+	return bestBackend
 }
 
-func (p *ServerPool) GetNextValidPeer() *Backend {
+func (p *ServerPool) GetLeastConnBackend() *Backend {
 	p.Mux.RLock()
 	defer p.Mux.RUnlock()
 
@@ -57,99 +73,254 @@ func (p *ServerPool) GetNextValidPeer() *Backend {
 		return nil
 	}
 
-	allWeightsEqual := true
-	firstWeight := p.Backends[0].Weight
-	for _, b := range p.Backends {
-		if b.Weight != firstWeight || b.Weight == 0 {
-			allWeightsEqual = false
-			break
+	now := time.Now()
+	var selected *Backend
+	minLoad := 0.0
+
+	// A warming backend counts as busier than it is, in proportion to how
+	// far it is from its full slow-start share.
+	for _, backend := range p.Backends {
+		if !backend.IsAvailable() {
+			continue
 		}
-	}
 
-	if allWeightsEqual && firstWeight == 0 {
-		return p.simpleRoundRobin()
+		load := float64(backend.GetCurrentConns()+1) / backend.slowStartFactor(p.SlowStart, now)
+		if selected == nil || load < minLoad {
+			selected = backend
+			minLoad = load
+		}
 	}
 
-	return p.weightedRoundRobin()
+	return selected
 }
 
-func (p *ServerPool) simpleRoundRobin() *Backend {
-	next := atomic.AddUint64(&p.Current, 1)
-	start := int(next) % len(p.Backends)
+// GetAlternateBackend returns the least loaded live backend not in exclude,
+// for retries whose strategy keeps choosing a backend that already failed.
+func (p *ServerPool) GetAlternateBackend(exclude []*Backend) *Backend {
+	p.Mux.RLock()
+	defer p.Mux.RUnlock()
 
-	for i := 0; i < len(p.Backends); i++ {
-		idx := (start + i) % len(p.Backends)
-		backend := p.Backends[idx]
+	now := time.Now()
+	var selected *Backend
+	minLoad := 0.0
 
-		if backend.IsAlive() {
-			return backend
+	for _, backend := range p.Backends {
+		if !backend.IsAvailable() || slices.Contains(exclude, backend) {
+			continue
+		}
+
+		load := float64(backend.GetCurrentConns()+1) / backend.slowStartFactor(p.SlowStart, now)
+		if selected == nil || load < minLoad {
+			selected = backend
+			minLoad = load
 		}
 	}
-	return nil
-}
 
-func (p *ServerPool) weightedRoundRobin() *Backend {
-	totalWeight := 0
-	var bestBackend *Backend
-	maxEffectiveWeight := -1
+	return selected
+}
 
+// GetP2CEWMABackend picks two random live backends and keeps the one with the
+// lower latency average scaled by its in-flight requests.
+func (p *ServerPool) GetP2CEWMABackend() *Backend {
+	p.Mux.RLock()
+	var live []*Backend
 	for _, backend := range p.Backends {
-		if !backend.IsAlive() {
-			continue
+		if backend.IsAvailable() {
+			live = append(live, backend)
 		}
+	}
+	p.Mux.RUnlock()
 
-		totalWeight += backend.Weight
+	switch len(live) {
+	case 0:
+		return nil
+	case 1:
+		return live[0]
+	}
 
-		effectiveWeight := backend.Weight
-		if bestBackend == nil || effectiveWeight > maxEffectiveWeight {
-			bestBackend = backend
-			maxEffectiveWeight = effectiveWeight
+	i := rand.Intn(len(live))
+	j := rand.Intn(len(live) - 1)
+	if j >= i {
+		j++
+	}
+
+	now := time.Now()
+	first, second := live[i], live[j]
+	if p.p2cCost(second, now) < p.p2cCost(first, now) {
+		return second
+	}
+	return first
+}
+
+func (p *ServerPool) p2cCost(backend *Backend, now time.Time) float64 {
+	return float64(backend.GetEWMALatency()+1) * float64(backend.GetCurrentConns()+1) / backend.slowStartFactor(p.SlowStart, now)
+}
+
+func (p *ServerPool) GetConsistentHashBackend(key string) *Backend {
+	p.Mux.RLock()
+	ring := p.ring
+	p.Mux.RUnlock()
+
+	if ring == nil {
+		p.Mux.Lock()
+		if p.ring == nil {
+			p.ring = newHashRing(p.Backends, p.HashVirtualNodes)
 		}
+		ring = p.ring
+		p.Mux.Unlock()
 	}
 
-	if bestBackend == nil {
-		return nil
+	return ring.get(key, p.HashLoadFactor)
+}
+
+func (p *ServerPool) AddBackend(backend *Backend) {
+	p.Mux.Lock()
+	p.addBackend(backend)
+	p.Mux.Unlock()
+}
+
+// CreateBackend adds the backend unless one with the same URL is already in
+// the pool, checking and adding under one lock so concurrent calls cannot
+// both add it. A backend created disabled never receives traffic.
+func (p *ServerPool) CreateBackend(backend *Backend, enabled bool) error {
+	p.Mux.Lock()
+	defer p.Mux.Unlock()
+
+	for _, b := range p.Backends {
+		if b.URL.String() == backend.URL.String() {
+			return ErrBackendExists
+		}
 	}
+	backend.disabled = !enabled
+	p.addBackend(backend)
+	return nil
+}
 
-	return bestBackend
+// addBackend appends the backend. The caller holds p.Mux.
+func (p *ServerPool) addBackend(backend *Backend) {
+	if backend.Weight == 0 {
+		backend.Weight = 1
+	}
+	if backend.ID == "" {
+		backend.ID = backendID(backend.URL)
+	}
+	backend.initProxy(p.Transport)
+	if p.SlowStart.Window > 0 {
+		backend.markWarmingUp(time.Now())
+	}
+
+	p.Backends = append(p.Backends, backend)
+	p.resetWeights()
 }
 
-func (p *ServerPool) GetLeastConnBackend() *Backend {
+func (p *ServerPool) GetBackendByID(id string) *Backend {
 	p.Mux.RLock()
 	defer p.Mux.RUnlock()
 
-	if len(p.Backends) == 0 {
-		return nil
+	for _, backend := range p.Backends {
+		if backend.ID == id {
+			return backend
+		}
 	}
+	return nil
+}
 
-	var selected *Backend
-	minConns := int64(-1)
+func (p *ServerPool) GetBackendByURL(uri *url.URL) *Backend {
+	p.Mux.RLock()
+	defer p.Mux.RUnlock()
 
 	for _, backend := range p.Backends {
-		if !backend.IsAlive() {
-			continue
+		if backend.URL.String() == uri.String() {
+			return backend
 		}
+	}
+	return nil
+}
 
-		conns := backend.GetCurrentConns()
-		if selected == nil || conns < minConns {
-			selected = backend
-			minConns = conns
+func (p *ServerPool) RemoveBackend(uri *url.URL) *Backend {
+	backend := p.GetBackendByURL(uri)
+	if backend == nil {
+		return nil
+	}
+	return p.RemoveBackendByID(backend.ID)
+}
+
+// RemoveBackendByID takes the backend out of the pool. It is flagged as
+// removed so sticky sessions still pointing at it pick a new backend.
+func (p *ServerPool) RemoveBackendByID(id string) *Backend {
+	p.Mux.Lock()
+	defer p.Mux.Unlock()
+
+	for i, backend := range p.Backends {
+		if backend.ID == id {
+			p.Backends = append(p.Backends[:i:i], p.Backends[i+1:]...)
+			p.resetWeights()
+
+			backend.mux.Lock()
+			backend.removed = true
+			backend.mux.Unlock()
+			return backend
 		}
 	}
+	return nil
+}
 
-	return selected
+type BackendUpdate struct {
+	Weight   *int              `json:"weight"`
+	Enabled  *bool             `json:"enabled"`
+	MaxConns *int64            `json:"max_conns"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
 }
 
-func (p *ServerPool) AddBackend(backend *Backend) {
+// UpdateBackend applies the non-nil fields of update. A weight change
+// restarts the round-robin sequence and rebuilds the hash ring.
+func (p *ServerPool) UpdateBackend(id string, update BackendUpdate) (*Backend, error) {
 	p.Mux.Lock()
+	defer p.Mux.Unlock()
 
-	if backend.Weight == 0 {
-		backend.Weight = 1
+	var backend *Backend
+	for _, b := range p.Backends {
+		if b.ID == id {
+			backend = b
+			break
+		}
+	}
+	if backend == nil {
+		return nil, ErrBackendNotFound
 	}
 
-	p.Backends = append(p.Backends, backend)
-	p.updateWeightMetrics()
-	p.Mux.Unlock()
+	if update.Weight != nil {
+		backend.Weight = *update.Weight
+		p.resetWeights()
+	}
+
+	backend.mux.Lock()
+	if update.Enabled != nil {
+		backend.disabled = !*update.Enabled
+	}
+	if update.MaxConns != nil {
+		backend.MaxConns = *update.MaxConns
+	}
+	if update.Tags != nil {
+		backend.Tags = update.Tags
+	}
+	if update.Metadata != nil {
+		backend.Metadata = update.Metadata
+	}
+	backend.mux.Unlock()
+
+	return backend, nil
+}
+
+// resetWeights restarts the smooth weighted round-robin sequence after the
+// set of backends changed so a new backend does not start behind the others,
+// and drops the hash ring so it is rebuilt on the next lookup.
+func (p *ServerPool) resetWeights() {
+	for _, backend := range p.Backends {
+		backend.currentWeight = 0
+	}
+	p.ring = nil
 }
 
 func (p *ServerPool) SetBackendStatus(uri *url.URL, alive bool) {
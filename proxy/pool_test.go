@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+)
+
+func newTestBackend(t *testing.T, rawURL string, weight int) *Backend {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Backend{URL: u, Alive: true, Weight: weight}
+}
+
+// newWeightedPool returns a pool of backends a, b and c weighted 5, 3 and 2.
+func newWeightedPool(t *testing.T) (*ServerPool, map[*Backend]string) {
+	t.Helper()
+	pool := &ServerPool{}
+	names := make(map[*Backend]string)
+	for _, spec := range []struct {
+		name   string
+		weight int
+	}{{"a", 5}, {"b", 3}, {"c", 2}} {
+		backend := newTestBackend(t, "http://"+spec.name+".test", spec.weight)
+		pool.AddBackend(backend)
+		names[backend] = spec.name
+	}
+	return pool, names
+}
+
+func pick(t *testing.T, pool *ServerPool, names map[*Backend]string, n int) []string {
+	t.Helper()
+	picks := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		backend := pool.GetNextValidPeer()
+		if backend == nil {
+			t.Fatalf("pick %d: no backend", i)
+		}
+		picks = append(picks, names[backend])
+	}
+	return picks
+}
+
+func count(picks []string) map[string]int {
+	counts := make(map[string]int)
+	for _, name := range picks {
+		counts[name]++
+	}
+	return counts
+}
+
+func assertCounts(t *testing.T, got map[string]int, want map[string]int) {
+	t.Helper()
+	for name, n := range want {
+		if got[name] != n {
+			t.Errorf("backend %s picked %d times, want %d (all: %v)", name, got[name], n, got)
+		}
+	}
+	for name, n := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("backend %s picked %d times, want 0", name, n)
+		}
+	}
+}
+
+func TestGetNextValidPeerSmoothSequence(t *testing.T) {
+	pool, names := newWeightedPool(t)
+
+	want := []string{"a", "b", "c", "a", "a", "b", "a", "c", "b", "a"}
+	for cycle := 0; cycle < 3; cycle++ {
+		got := pick(t, pool, names, len(want))
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("cycle %d: sequence %v, want %v", cycle, got, want)
+			}
+		}
+	}
+}
+
+func TestGetNextValidPeerDistribution(t *testing.T) {
+	pool, names := newWeightedPool(t)
+
+	assertCounts(t, count(pick(t, pool, names, 1000)), map[string]int{"a": 500, "b": 300, "c": 200})
+}
+
+func TestGetNextValidPeerAfterAddBackend(t *testing.T) {
+	pool, names := newWeightedPool(t)
+	pick(t, pool, names, 4)
+
+	d := newTestBackend(t, "http://d.test", 10)
+	pool.AddBackend(d)
+	names[d] = "d"
+
+	// The sequence restarts, so every full cycle of 20 picks is exact.
+	for cycle := 0; cycle < 3; cycle++ {
+		assertCounts(t, count(pick(t, pool, names, 20)), map[string]int{"a": 5, "b": 3, "c": 2, "d": 10})
+	}
+}
+
+func TestGetNextValidPeerAfterRemoveBackend(t *testing.T) {
+	pool, names := newWeightedPool(t)
+	pick(t, pool, names, 3)
+
+	var b *Backend
+	for backend, name := range names {
+		if name == "b" {
+			b = backend
+		}
+	}
+	if removed := pool.RemoveBackendByID(b.ID); removed != b {
+		t.Fatalf("RemoveBackendByID returned %v, want backend b", removed)
+	}
+
+	for cycle := 0; cycle < 3; cycle++ {
+		assertCounts(t, count(pick(t, pool, names, 7)), map[string]int{"a": 5, "c": 2})
+	}
+}
+
+func TestGetNextValidPeerBackendDownMidCycle(t *testing.T) {
+	pool, names := newWeightedPool(t)
+	pick(t, pool, names, 3)
+
+	var a *Backend
+	for backend, name := range names {
+		if name == "a" {
+			a = backend
+		}
+	}
+	a.SetAlive(false)
+
+	// b and c carry their state over from the interrupted cycle, so a
+	// window may be off by one, but never includes a.
+	counts := count(pick(t, pool, names, 50))
+	if counts["a"] != 0 {
+		t.Errorf("backend a picked %d times while down", counts["a"])
+	}
+	if counts["b"] < 29 || counts["b"] > 31 || counts["b"]+counts["c"] != 50 {
+		t.Errorf("got %v, want about 30 b and 20 c", counts)
+	}
+
+	a.SetAlive(true)
+	pick(t, pool, names, 10)
+	assertCounts(t, count(pick(t, pool, names, 1000)), map[string]int{"a": 500, "b": 300, "c": 200})
+}
+
+func TestGetNextValidPeerNoBackendAvailable(t *testing.T) {
+	pool, _ := newWeightedPool(t)
+	for _, backend := range pool.Backends {
+		backend.SetAlive(false)
+	}
+	if backend := pool.GetNextValidPeer(); backend != nil {
+		t.Fatalf("got %s, want nil with every backend down", backend.URL)
+	}
+	if backend := (&ServerPool{}).GetNextValidPeer(); backend != nil {
+		t.Fatalf("got %s from an empty pool", backend.URL)
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyProtoHeaderTimeout = 5 * time.Second
+
+// ProxyProtocolListener accepts HAProxy PROXY protocol v1 and v2 headers from
+// trusted peers and reports the source address they carry as RemoteAddr.
+// Connections from untrusted peers are served as they are.
+type ProxyProtocolListener struct {
+	net.Listener
+	Resolver *ClientIPResolver
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if peer == nil || !l.Resolver.IsTrusted(peer.IP) {
+		return conn, nil
+	}
+
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyProtoConn parses the header lazily on first use so a slow peer only
+// blocks its own connection goroutine, never Accept.
+type proxyProtoConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	sourceAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout))
+		c.sourceAddr, c.err = readProxyProtoHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.sourceAddr != nil {
+		return c.sourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtoHeader consumes a PROXY header if one is present. It returns
+// a nil address when there is no header or it carries no source (LOCAL/UNKNOWN).
+func readProxyProtoHeader(reader *bufio.Reader) (net.Addr, error) {
+	if sig, err := reader.Peek(len(proxyProtoV2Signature)); err == nil && bytes.Equal(sig, proxyProtoV2Signature) {
+		return readProxyProtoV2(reader)
+	}
+	if prefix, err := reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+		return readProxyProtoV1(reader)
+	}
+	return nil, nil
+}
+
+func readProxyProtoV1(reader *bufio.Reader) (net.Addr, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > 107 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("proxy protocol: invalid v1 header")
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(line, "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("proxy protocol: invalid v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, errors.New("proxy protocol: invalid v1 source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyProtoV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, errors.New("proxy protocol: unsupported v2 version")
+	}
+	command := header[12] & 0x0f
+	family := header[13] >> 4
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections are health checks from the proxy itself.
+	if command == 0 {
+		return nil, nil
+	}
+
+	switch family {
+	case 1:
+		if len(payload) < 12 {
+			return nil, errors.New("proxy protocol: short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2:
+		if len(payload) < 36 {
+			return nil, errors.New("proxy protocol: short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
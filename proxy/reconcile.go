@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// removalDrainTimeout bounds how long a backend dropped from the
+// configuration keeps serving its in-flight requests.
+const removalDrainTimeout = 30 * time.Second
+
+// BackendSpec is the configured state of a backend.
+type BackendSpec struct {
+	URL    *url.URL
+	Weight int
+}
+
+type PoolOptions struct {
+	HashVirtualNodes int
+	HashLoadFactor   float64
+	Transport        TransportOptions
+	Outlier          OutlierOptions
+	Breaker          BreakerOptions
+	SlowStart        SlowStartOptions
+}
+
+// Reconcile brings a running pool in line with a new configuration: missing
+// backends are added, backends that are no longer configured are drained and
+// then removed, and weights are updated. Backends that stay keep their
+// health, connection, outlier and drain state, and backends added through
+// the admin API are kept. It returns a description of every change made.
+func (p *ServerPool) Reconcile(specs []BackendSpec, opts PoolOptions) []string {
+	var changes []string
+
+	p.Mux.Lock()
+	transportChanged := p.Transport != opts.Transport
+	if p.HashVirtualNodes != opts.HashVirtualNodes || p.HashLoadFactor != opts.HashLoadFactor {
+		changes = append(changes, "consistent-hash options updated")
+	}
+	if p.Outlier != opts.Outlier {
+		changes = append(changes, "outlier detection options updated")
+	}
+	breakerDisabled := p.Breaker.Enabled && !opts.Breaker.Enabled
+	if p.Breaker != opts.Breaker {
+		changes = append(changes, "circuit breaker options updated")
+	}
+	if p.SlowStart != opts.SlowStart {
+		changes = append(changes, "slow start options updated")
+	}
+	p.HashVirtualNodes = opts.HashVirtualNodes
+	p.HashLoadFactor = opts.HashLoadFactor
+	p.Transport = opts.Transport
+	p.Outlier = opts.Outlier
+	p.Breaker = opts.Breaker
+	p.SlowStart = opts.SlowStart
+	p.resetWeights()
+	current := make([]*Backend, len(p.Backends))
+	copy(current, p.Backends)
+	p.Mux.Unlock()
+
+	if breakerDisabled {
+		for _, backend := range current {
+			backend.resetBreaker()
+		}
+	}
+
+	if transportChanged {
+		for _, backend := range current {
+			backend.resetProxy(opts.Transport)
+		}
+		changes = append(changes, "transport options updated")
+	}
+
+	wanted := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		wanted[spec.URL.String()] = true
+	}
+	for _, backend := range current {
+		if wanted[backend.URL.String()] {
+			continue
+		}
+		backend.mux.RLock()
+		configured := backend.configured
+		backend.mux.RUnlock()
+
+		switch {
+		case !configured:
+			log.Printf("Keeping backend %s added through the admin API, it is not in the configuration", backend.URL.String())
+		case backend.IsRemoving():
+		default:
+			p.drainAndRemove(backend, removalDrainTimeout)
+			changes = append(changes, fmt.Sprintf("removing backend %s after draining %d connection(s)", backend.URL, backend.GetCurrentConns()))
+		}
+	}
+
+	for _, spec := range specs {
+		weight := spec.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		backend := p.GetBackendByURL(spec.URL)
+		if backend == nil {
+			p.AddBackend(&Backend{URL: spec.URL, Alive: true, Weight: weight, configured: true})
+			changes = append(changes, fmt.Sprintf("added backend %s (weight %d)", spec.URL, weight))
+			continue
+		}
+
+		if backend.IsRemoving() {
+			p.UndrainBackend(backend.ID)
+			changes = append(changes, fmt.Sprintf("kept backend %s, its removal was cancelled", spec.URL))
+		}
+		backend.mux.Lock()
+		backend.configured = true
+		backend.mux.Unlock()
+
+		p.Mux.RLock()
+		oldWeight := backend.Weight
+		p.Mux.RUnlock()
+		if oldWeight != weight {
+			p.UpdateBackend(backend.ID, BackendUpdate{Weight: &weight})
+			changes = append(changes, fmt.Sprintf("weight of %s changed from %d to %d", spec.URL, oldWeight, weight))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func specsFor(t *testing.T, rawURLs ...string) []BackendSpec {
+	t.Helper()
+	specs := make([]BackendSpec, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, BackendSpec{URL: u, Weight: 1})
+	}
+	return specs
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileDrainsRemovedBackends(t *testing.T) {
+	pool := &ServerPool{}
+	pool.Reconcile(specsFor(t, "http://a.test", "http://b.test"), PoolOptions{})
+
+	b := pool.GetBackendByURL(specsFor(t, "http://b.test")[0].URL)
+	b.IncrementConnections()
+
+	pool.Reconcile(specsFor(t, "http://a.test"), PoolOptions{})
+	if !b.IsRemoving() || b.IsAvailable() {
+		t.Fatal("removed backend is not draining")
+	}
+	if pool.GetBackendByID(b.ID) == nil {
+		t.Fatal("backend removed while a request is in flight")
+	}
+
+	b.DecrementConnections()
+	waitFor(t, "the drained backend to be removed", func() bool {
+		return pool.GetBackendByID(b.ID) == nil
+	})
+}
+
+func TestReconcileCancelsRemovalOfReaddedBackend(t *testing.T) {
+	pool := &ServerPool{}
+	pool.Reconcile(specsFor(t, "http://a.test", "http://b.test"), PoolOptions{})
+
+	b := pool.GetBackendByURL(specsFor(t, "http://b.test")[0].URL)
+	b.IncrementConnections()
+	pool.Reconcile(specsFor(t, "http://a.test"), PoolOptions{})
+	pool.Reconcile(specsFor(t, "http://a.test", "http://b.test"), PoolOptions{})
+	b.DecrementConnections()
+
+	if b.IsRemoving() || b.IsDraining() {
+		t.Fatal("backend back in the configuration is still draining")
+	}
+	time.Sleep(3 * drainPollInterval)
+	if pool.GetBackendByID(b.ID) == nil {
+		t.Fatal("backend back in the configuration was removed")
+	}
+}
+
+func TestReconcileKeepsAdminAddedBackends(t *testing.T) {
+	pool := &ServerPool{}
+	pool.Reconcile(specsFor(t, "http://a.test"), PoolOptions{})
+
+	added := newTestBackend(t, "http://added.test", 1)
+	pool.AddBackend(added)
+
+	pool.Reconcile(specsFor(t, "http://a.test"), PoolOptions{})
+	if pool.GetBackendByID(added.ID) == nil || added.IsDraining() {
+		t.Fatal("reload removed a backend added through the admin API")
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type requestInfoKey struct{}
+
+// RequestInfo is what ProxyHandler learned about a request, for middlewares
+// such as the access log that wrap the router and cannot see the backend.
+type RequestInfo struct {
+	Route           string
+	Backend         string
+	UpstreamLatency time.Duration
+	// Retries counts the attempts after the first.
+	Retries int
+}
+
+// WithRequestInfo attaches an empty RequestInfo to the request, to be read
+// once the handler returns.
+func WithRequestInfo(r *http.Request) (*http.Request, *RequestInfo) {
+	info := &RequestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// requestInfo returns the RequestInfo of the request, or a throwaway one when
+// no middleware asked for it.
+func requestInfo(r *http.Request) *RequestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+type requestIDKey struct{}
+
+// requestIDPattern limits accepted request IDs to characters that are safe
+// in headers, log lines and error pages.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=@-]{1,128}$`)
+
+type requestID struct {
+	header string
+	id     string
+}
+
+// RequestIDs assigns every request a correlation ID, stored in Header. A
+// valid incoming ID is kept when AcceptIncoming is set; otherwise, or when
+// the incoming value is missing or invalid, a random UUID is generated.
+type RequestIDs struct {
+	Header         string
+	AcceptIncoming bool
+}
+
+// Middleware stores the ID in the request context, where RequestID reads it,
+// sets it on the request so it is forwarded to the backend, and echoes it in
+// the response.
+func (ri RequestIDs) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		if ri.AcceptIncoming {
+			if values := r.Header.Values(ri.Header); len(values) == 1 && requestIDPattern.MatchString(values[0]) {
+				id = values[0]
+			}
+		}
+		if id == "" {
+			id = newRequestID()
+		}
+
+		r.Header.Set(ri.Header, id)
+		w.Header().Set(ri.Header, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID{header: ri.Header, id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestID returns the ID assigned by RequestIDs.Middleware, or "" when the
+// request did not go through it.
+func RequestID(r *http.Request) string {
+	return requestIDFrom(r.Context()).id
+}
+
+func requestIDFrom(ctx context.Context) requestID {
+	rid, _ := ctx.Value(requestIDKey{}).(requestID)
+	return rid
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// WriteError writes an error page that carries the request ID, so a user
+// reporting the error can be matched with the proxy and backend logs.
+func WriteError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if id := RequestID(r); id != "" {
+		message += "\nRequest ID: " + id
+	}
+	http.Error(w, message, code)
+}
+
+// logPrefix identifies the request in the proxy's log lines.
+func logPrefix(r *http.Request) string {
+	if id := RequestID(r); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
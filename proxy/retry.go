@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryOptions control retrying a failed request on another backend.
+type RetryOptions struct {
+	// MaxRetries is the number of attempts after the first; 0 disables
+	// retries.
+	MaxRetries     int
+	OnConnectError bool
+	OnTimeout      bool
+	// Statuses are backend response codes retried like errors, e.g. 503.
+	Statuses []int
+	// PerTryTimeout bounds the wait for the response headers of one
+	// attempt; the route timeout still bounds the whole request.
+	PerTryTimeout time.Duration
+	// Backoff is the base delay before a retry, doubled on each further
+	// retry up to MaxBackoff, and jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// NonIdempotent also retries methods such as POST, whose bodies are
+	// replayed from a buffer of at most MaxBodyBytes.
+	NonIdempotent bool
+	MaxBodyBytes  int64
+	Budget        *RetryBudget
+}
+
+// errRetry makes ReverseProxy discard a response that will be retried.
+var errRetry = errors.New("response discarded for a retry")
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (opts RetryOptions) retriesStatus(code int) bool {
+	for _, status := range opts.Statuses {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryReason classifies a transport error as "connect-error" or "timeout",
+// or returns "" when the error is not retryable: the request may already
+// have reached the backend.
+func (opts RetryOptions) retryReason(err error, perTryTimedOut bool) string {
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case perTryTimedOut, errors.As(err, &netErr) && netErr.Timeout():
+		if opts.OnTimeout {
+			return "timeout"
+		}
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opts.OnConnectError {
+			return "connect-error"
+		}
+	}
+	return ""
+}
+
+// backoff returns the full-jitter delay before retry n (1-based).
+func (opts RetryOptions) backoff(n int) time.Duration {
+	if opts.Backoff <= 0 {
+		return 0
+	}
+	delay := opts.Backoff
+	for i := 1; i < n && delay < opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if opts.MaxBackoff > 0 && delay > opts.MaxBackoff {
+		delay = opts.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// replayableBody buffers the request body so it can be sent again. It
+// reports false, leaving the body readable from the start, when the body is
+// larger than limit.
+func replayableBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, true
+	}
+	if r.ContentLength > limit {
+		return nil, false
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(buf)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false
+	}
+	return buf, true
+}
+
+const (
+	budgetBuckets = 10
+	budgetBucket  = time.Second
+)
+
+// RetryBudget caps the retries of a pool to a share of its recent requests,
+// so a failing backend cannot multiply the load on the others. At least
+// MinPerSecond retries per second are always allowed for quiet pools.
+type RetryBudget struct {
+	mux          sync.Mutex
+	ratio        float64
+	minPerSecond int
+	buckets      [budgetBuckets]budgetCount
+}
+
+type budgetCount struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+	return &RetryBudget{ratio: ratio, minPerSecond: minPerSecond}
+}
+
+// Configure changes the limits and keeps the recent counts.
+func (b *RetryBudget) Configure(ratio float64, minPerSecond int) {
+	b.mux.Lock()
+	b.ratio = ratio
+	b.minPerSecond = minPerSecond
+	b.mux.Unlock()
+}
+
+func (b *RetryBudget) bucket(now time.Time) *budgetCount {
+	second := now.Unix()
+	bucket := &b.buckets[second%budgetBuckets]
+	if bucket.second != second {
+		*bucket = budgetCount{second: second}
+	}
+	return bucket
+}
+
+func (b *RetryBudget) recordRequest() {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	b.bucket(time.Now()).requests++
+	b.mux.Unlock()
+}
+
+// withdraw reserves a retry if the budget allows one.
+func (b *RetryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := time.Now()
+	current := b.bucket(now)
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Unix()-bucket.second < budgetBuckets {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := max(int(b.ratio*float64(requests)), b.minPerSecond*budgetBuckets)
+	if retries >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// refund returns a retry reserved by withdraw that was not sent. It is called
+// right after withdraw, so the retry is in the current or the previous second.
+func (b *RetryBudget) refund() {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	second := time.Now().Unix()
+	for _, s := range []int64{second, second - 1} {
+		bucket := &b.buckets[s%budgetBuckets]
+		if bucket.second == s && bucket.retries > 0 {
+			bucket.retries--
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetRefund(t *testing.T) {
+	budget := NewRetryBudget(0, 1)
+	// One retry per second over the window of budgetBuckets seconds.
+	for i := 0; i < budgetBuckets; i++ {
+		if !budget.withdraw() {
+			t.Fatalf("withdraw %d refused", i)
+		}
+	}
+	if budget.withdraw() {
+		t.Fatal("withdraw allowed past the budget")
+	}
+
+	budget.refund()
+	if !budget.withdraw() {
+		t.Fatal("withdraw refused after a refund")
+	}
+}
+
+func TestReleaseTrial(t *testing.T) {
+	backend := newTestBackend(t, "http://a.test", 1)
+	backend.breaker = breakerState{state: CircuitHalfOpen, trialLimit: 1, trialTimeout: time.Minute, halfOpenSince: time.Now()}
+
+	if !backend.allowRequest() {
+		t.Fatal("half-open circuit refused the first trial")
+	}
+	if backend.allowRequest() {
+		t.Fatal("half-open circuit allowed a second trial")
+	}
+
+	backend.releaseTrial()
+	if !backend.allowRequest() {
+		t.Fatal("released trial slot was not given to the next request")
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type Route struct {
+	Name       string
+	Host       string
+	PathPrefix string
+	PathRegex  *regexp.Regexp
+	Methods    []string
+	Headers    map[string]string
+	Handler    http.Handler
+}
+
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.Host != "" && !matchHost(rt.Host, r.Host) {
+		return false
+	}
+
+	if rt.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+
+	if rt.PathRegex != nil && !rt.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+
+	if len(rt.Methods) > 0 {
+		found := false
+		for _, method := range rt.Methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for name, value := range rt.Headers {
+		got := r.Header.Get(name)
+		if value == "" {
+			if got == "" {
+				return false
+			}
+		} else if got != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchHost compares the pattern with the request host ignoring the port.
+// A pattern starting with "*." matches any subdomain.
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+type Router struct {
+	routes   []*Route
+	fallback http.Handler
+}
+
+func NewRouter(fallback http.Handler) *Router {
+	return &Router{
+		fallback: fallback,
+	}
+}
+
+func (router *Router) AddRoute(route *Route) {
+	router.routes = append(router.routes, route)
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range router.routes {
+		if route.Matches(r) {
+			route.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	if router.fallback == nil {
+		WriteError(w, r, "404 No route matched", http.StatusNotFound)
+		return
+	}
+	router.fallback.ServeHTTP(w, r)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"math"
+	"time"
+)
+
+type SlowStartOptions struct {
+	// Window is how long a backend ramps up after joining or recovering;
+	// zero disables slow start.
+	Window time.Duration
+	// Aggression shapes the ramp: 1 is linear, higher values reach the full
+	// weight faster.
+	Aggression float64
+	// MinWeightPercent is the share of the weight a backend starts with.
+	MinWeightPercent int
+}
+
+// markWarmingUp starts the slow-start window of the backend.
+func (b *Backend) markWarmingUp(now time.Time) {
+	b.mux.Lock()
+	b.upSince = now
+	b.mux.Unlock()
+}
+
+// slowStartFactor returns the fraction of its weight the backend currently
+// gets: (elapsed/window)^(1/aggression), floored at MinWeightPercent.
+func (b *Backend) slowStartFactor(opts SlowStartOptions, now time.Time) float64 {
+	if opts.Window <= 0 {
+		return 1
+	}
+
+	b.mux.RLock()
+	upSince := b.upSince
+	b.mux.RUnlock()
+
+	if upSince.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(upSince)
+	if elapsed >= opts.Window {
+		return 1
+	}
+
+	aggression := opts.Aggression
+	if aggression <= 0 {
+		aggression = 1
+	}
+
+	factor := math.Pow(float64(elapsed)/float64(opts.Window), 1/aggression)
+	return math.Max(factor, float64(opts.MinWeightPercent)/100)
+}
+
+// effectiveWeight is the backend weight scaled by 100 and by the slow-start
+// factor, so a warming backend still gets a small non-zero share.
+func (p *ServerPool) effectiveWeight(backend *Backend, now time.Time) int {
+	weight := backend.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	scaled := int(float64(weight*100) * backend.slowStartFactor(p.SlowStart, now))
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
@@ -1,9 +1,12 @@
 package proxy
 
 import (
-    "net"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
     "net/http"
     "net/url"
+    "strings"
     "sync"
     "time"
 )
@@ -13,18 +16,44 @@ type StickySession struct {
     LastSeen time.Time
 }
 
+type StickyOptions struct {
+    // Mode is "ip" (default), "cookie" for a signed affinity cookie set by
+    // the proxy, or "app-cookie" to follow an application session cookie.
+    Mode       string
+    CookieName string
+    Secret     []byte
+    AppCookie  string
+    // Route scopes the affinity cookie to a route by suffixing its name, so
+    // a client moving between routes keeps one valid cookie per route.
+    Route      string
+}
+
 type StickySessionPool struct {
     pool     *ServerPool
     sessions map[string]*StickySession
     mux      sync.RWMutex
     ttl      time.Duration
+    opts     StickyOptions
+    done     chan struct{}
 }
 
-func NewStickySessionPool(pool *ServerPool, ttl time.Duration) *StickySessionPool {
+func NewStickySessionPool(pool *ServerPool, ttl time.Duration, opts StickyOptions) *StickySessionPool {
+    if opts.Mode == "" {
+        opts.Mode = "ip"
+    }
+    if opts.CookieName == "" {
+        opts.CookieName = "PROXY_AFFINITY"
+    }
+    if opts.Route != "" {
+        opts.CookieName = RouteCookieName(opts.CookieName, opts.Route)
+    }
+
     sp := &StickySessionPool{
         pool:     pool,
         sessions: make(map[string]*StickySession),
         ttl:      ttl,
+        opts:     opts,
+        done:     make(chan struct{}),
     }
     
     go sp.cleanupExpiredSessions()
@@ -32,14 +61,25 @@ func NewStickySessionPool(pool *ServerPool, ttl time.Duration) *StickySessionPoo
     return sp
 }
 
-func (sp *StickySessionPool) GetBackendForClient(r *http.Request) *Backend {
-    clientIP := getClientIP(r)
+// SessionCount returns the number of entries in the session table.
+func (sp *StickySessionPool) SessionCount() int {
+    sp.mux.RLock()
+    defer sp.mux.RUnlock()
+    return len(sp.sessions)
+}
+
+func (sp *StickySessionPool) GetBackendForClient(w http.ResponseWriter, r *http.Request) *Backend {
+    if sp.opts.Mode == "cookie" {
+        return sp.getBackendFromCookie(w, r)
+    }
+
+    key := sp.sessionKey(r)
     
     sp.mux.RLock()
-    session, exists := sp.sessions[clientIP]
+    session, exists := sp.sessions[key]
     sp.mux.RUnlock()
     
-    if exists && session.Backend.IsAlive() {
+    if exists && session.Backend.IsAvailable() {
         sp.mux.Lock()
         session.LastSeen = time.Now()
         sp.mux.Unlock()
@@ -49,17 +89,99 @@ func (sp *StickySessionPool) GetBackendForClient(r *http.Request) *Backend {
     backend := sp.pool.GetNextValidPeer()
     
     if backend != nil {
-        sp.mux.Lock()
-        sp.sessions[clientIP] = &StickySession{
-            Backend:  backend,
-            LastSeen: time.Now(),
-        }
-        sp.mux.Unlock()
+        sp.assign(key, backend)
     }
     
     return backend
 }
 
+// sessionKey identifies the client by its application session cookie in
+// "app-cookie" mode, and by client IP otherwise or when the cookie is absent.
+func (sp *StickySessionPool) sessionKey(r *http.Request) string {
+    if sp.opts.Mode == "app-cookie" {
+        if cookie, err := r.Cookie(sp.opts.AppCookie); err == nil && cookie.Value != "" {
+            return "app:" + cookie.Value
+        }
+    }
+    return "ip:" + ClientIP(r)
+}
+
+func (sp *StickySessionPool) assign(key string, backend *Backend) {
+    sp.mux.Lock()
+    sp.sessions[key] = &StickySession{
+        Backend:  backend,
+        LastSeen: time.Now(),
+    }
+    sp.mux.Unlock()
+}
+
+// ObserveResponse binds a new application session to the backend that created
+// it, so the request carrying the cookie next lands on the same backend.
+func (sp *StickySessionPool) ObserveResponse(resp *http.Response, backend *Backend) {
+    if sp.opts.Mode != "app-cookie" {
+        return
+    }
+
+    for _, cookie := range resp.Cookies() {
+        if cookie.Name == sp.opts.AppCookie && cookie.Value != "" {
+            sp.assign("app:"+cookie.Value, backend)
+        }
+    }
+}
+
+func (sp *StickySessionPool) getBackendFromCookie(w http.ResponseWriter, r *http.Request) *Backend {
+    if cookie, err := r.Cookie(sp.opts.CookieName); err == nil {
+        if id, ok := sp.verifyCookie(cookie.Value); ok {
+            if backend := sp.pool.GetBackendByID(id); backend != nil && backend.IsAvailable() {
+                return backend
+            }
+        }
+    }
+
+    backend := sp.pool.GetNextValidPeer()
+    if backend != nil {
+        http.SetCookie(w, &http.Cookie{
+            Name:     sp.opts.CookieName,
+            Value:    sp.signCookie(backend.ID),
+            Path:     "/",
+            MaxAge:   int(sp.ttl.Seconds()),
+            HttpOnly: true,
+            Secure:   r.TLS != nil,
+            SameSite: http.SameSiteLaxMode,
+        })
+    }
+    return backend
+}
+
+// RouteCookieName returns the affinity cookie name of a route. Characters not
+// allowed in a cookie name are replaced with an underscore.
+func RouteCookieName(base, route string) string {
+    suffix := strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+            return r
+        }
+        return '_'
+    }, route)
+    return base + "_" + suffix
+}
+
+func (sp *StickySessionPool) signCookie(id string) string {
+    mac := hmac.New(sha256.New, sp.opts.Secret)
+    mac.Write([]byte(id))
+    return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (sp *StickySessionPool) verifyCookie(value string) (string, bool) {
+    id, _, found := strings.Cut(value, ".")
+    if !found {
+        return "", false
+    }
+    if !hmac.Equal([]byte(sp.signCookie(id)), []byte(value)) {
+        return "", false
+    }
+    return id, true
+}
+
 func (sp *StickySessionPool) GetNextValidPeer() *Backend {
     return sp.pool.GetNextValidPeer()
 }
@@ -68,6 +190,22 @@ func (sp *StickySessionPool) GetLeastConnBackend() *Backend {
     return sp.pool.GetLeastConnBackend()
 }
 
+func (sp *StickySessionPool) GetConsistentHashBackend(key string) *Backend {
+    return sp.pool.GetConsistentHashBackend(key)
+}
+
+func (sp *StickySessionPool) GetP2CEWMABackend() *Backend {
+    return sp.pool.GetP2CEWMABackend()
+}
+
+func (sp *StickySessionPool) GetAlternateBackend(exclude []*Backend) *Backend {
+    return sp.pool.GetAlternateBackend(exclude)
+}
+
+func (sp *StickySessionPool) RecordResult(backend *Backend, statusCode int, err error) {
+    sp.pool.RecordResult(backend, statusCode, err)
+}
+
 func (sp *StickySessionPool) AddBackend(backend *Backend) {
     sp.pool.AddBackend(backend)
 }
@@ -86,11 +224,22 @@ func (sp *StickySessionPool) SetBackendStatus(uri *url.URL, alive bool) {
     }
 }
 
+// Close stops the session cleanup of a pool that is no longer in use.
+func (sp *StickySessionPool) Close() {
+    close(sp.done)
+}
+
 func (sp *StickySessionPool) cleanupExpiredSessions() {
     ticker := time.NewTicker(sp.ttl)
     defer ticker.Stop()
     
-    for range ticker.C {
+    for {
+        select {
+        case <-sp.done:
+            return
+        case <-ticker.C:
+        }
+
         sp.mux.Lock()
         now := time.Now()
         for ip, session := range sp.sessions {
@@ -101,13 +250,3 @@ func (sp *StickySessionPool) cleanupExpiredSessions() {
         sp.mux.Unlock()
     }
 }
-
-func getClientIP(r *http.Request) string {
-    forwarded := r.Header.Get("X-Forwarded-For")
-    if forwarded != "" {
-        return forwarded
-    }
-    
-    ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-    return ip
-}
\ No newline at end of file
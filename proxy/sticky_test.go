@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newCookiePool(t *testing.T, route string, urls ...string) *StickySessionPool {
+	t.Helper()
+	pool := &ServerPool{}
+	for _, rawURL := range urls {
+		pool.AddBackend(newTestBackend(t, rawURL, 1))
+	}
+	sp := NewStickySessionPool(pool, time.Hour, StickyOptions{Mode: "cookie", Secret: []byte("secret"), Route: route})
+	t.Cleanup(sp.Close)
+	return sp
+}
+
+// request sends the cookies of jar to sp, stores the cookies it sets and
+// returns the chosen backend.
+func request(sp *StickySessionPool, jar map[string]*http.Cookie) *Backend {
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.test/", nil)
+	for _, cookie := range jar {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	backend := sp.GetBackendForClient(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		jar[cookie.Name] = cookie
+	}
+	return backend
+}
+
+func TestStickyCookieScopedPerRoute(t *testing.T) {
+	api := newCookiePool(t, "api", "http://api-1.test", "http://api-2.test", "http://api-3.test")
+	web := newCookiePool(t, "web", "http://web-1.test", "http://web-2.test", "http://web-3.test")
+	jar := make(map[string]*http.Cookie)
+
+	apiBackend := request(api, jar)
+	webBackend := request(web, jar)
+	if len(jar) != 2 {
+		t.Fatalf("got cookies %v, want one per route", jar)
+	}
+	if _, ok := jar["PROXY_AFFINITY_api"]; !ok {
+		t.Fatalf("no PROXY_AFFINITY_api cookie in %v", jar)
+	}
+
+	for i := 0; i < 5; i++ {
+		if got := request(api, jar); got != apiBackend {
+			t.Fatalf("switch %d: api request went to %s, want %s", i, got.URL, apiBackend.URL)
+		}
+		if got := request(web, jar); got != webBackend {
+			t.Fatalf("switch %d: web request went to %s, want %s", i, got.URL, webBackend.URL)
+		}
+	}
+}
+
+func TestRouteCookieName(t *testing.T) {
+	if got := RouteCookieName("PROXY_AFFINITY", "my api/v1"); got != "PROXY_AFFINITY_my_api_v1" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+type TransportOptions struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	DisableHTTP2          bool
+}
+
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         30 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// withDefaults replaces unset options with DefaultTransportOptions.
+func (opts TransportOptions) withDefaults() TransportOptions {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultTransportOptions.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost == 0 {
+		opts.MaxIdleConnsPerHost = DefaultTransportOptions.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultTransportOptions.IdleConnTimeout
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultTransportOptions.DialTimeout
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultTransportOptions.KeepAlive
+	}
+	if opts.TLSHandshakeTimeout == 0 {
+		opts.TLSHandshakeTimeout = DefaultTransportOptions.TLSHandshakeTimeout
+	}
+	return opts
+}
+
+func NewTransport(opts TransportOptions) *http.Transport {
+	opts = opts.withDefaults()
+
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     !opts.DisableHTTP2,
+	}
+	if opts.DisableHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport
+}
+
+type attemptKey struct{}
+
+// proxyAttempt carries the per-request parts of proxying through the
+// backend's long-lived ReverseProxy, which is shared by all requests.
+type proxyAttempt struct {
+	forwarding ForwardingOptions
+	// headers are set on the outgoing request after forwarding is applied;
+	// a name without values removes the header.
+	headers        http.Header
+	modifyResponse func(*http.Response) error
+	errorHandler   func(http.ResponseWriter, *http.Request, error)
+}
+
+func withAttempt(r *http.Request, attempt *proxyAttempt) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), attemptKey{}, attempt))
+}
+
+func attemptFrom(ctx context.Context) *proxyAttempt {
+	attempt, _ := ctx.Value(attemptKey{}).(*proxyAttempt)
+	return attempt
+}
+
+func newBackendProxy(backend *Backend, transport http.RoundTripper) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(backend.URL)
+			if attempt := attemptFrom(pr.In.Context()); attempt != nil {
+				applyForwarding(pr, attempt.forwarding)
+				for name, values := range attempt.headers {
+					if len(values) == 0 {
+						pr.Out.Header.Del(name)
+					} else {
+						pr.Out.Header[name] = values
+					}
+				}
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if attempt := attemptFrom(resp.Request.Context()); attempt != nil && attempt.modifyResponse != nil {
+				return attempt.modifyResponse(resp)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if attempt := attemptFrom(r.Context()); attempt != nil && attempt.errorHandler != nil {
+				attempt.errorHandler(w, r, err)
+				return
+			}
+			log.Printf("%sBackend %s failed: %v", logPrefix(r), backend.URL.String(), err)
+			WriteError(w, r, "502 Bad Gateway", http.StatusBadGateway)
+		},
+	}
+}
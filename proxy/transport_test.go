@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// BenchmarkBackendProxy compares building a reverse proxy and transport per
+// request, which dials a new connection every time, with the long-lived
+// per-backend proxy that reuses pooled keep-alive connections.
+func BenchmarkBackendProxy(b *testing.B) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	serve := func(b *testing.B, proxyFor func() (http.Handler, func())) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				handler, done := proxyFor()
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				done()
+				if w.Code != http.StatusOK {
+					b.Errorf("status %d, want 200", w.Code)
+					return
+				}
+			}
+		})
+	}
+
+	b.Run("per-request", func(b *testing.B) {
+		backend := &Backend{URL: u, Alive: true}
+		serve(b, func() (http.Handler, func()) {
+			transport := NewTransport(DefaultTransportOptions)
+			// A dropped transport keeps its idle connection open until it is
+			// collected; close it so the benchmark does not run out of
+			// file descriptors.
+			return newBackendProxy(backend, transport), transport.CloseIdleConnections
+		})
+	})
+
+	b.Run("long-lived", func(b *testing.B) {
+		backend := &Backend{URL: u, Alive: true}
+		backend.initProxy(DefaultTransportOptions)
+		serve(b, func() (http.Handler, func()) {
+			return backend.ReverseProxy(), func() {}
+		})
+	})
+}
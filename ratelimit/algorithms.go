@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// counter is the state of one key under one rule. Counters are guarded by
+// their rule's mux.
+type counter interface {
+	// take counts a request if the limit allows it.
+	take(now time.Time) decision
+	// peek reports the state without counting a request.
+	peek(now time.Time) decision
+	lastUsed() time.Time
+}
+
+type decision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset is the time until the counter is back at its full limit.
+	reset time.Duration
+	// retryAfter is the time until a rejected request would be allowed.
+	retryAfter time.Duration
+}
+
+// tokenBucket holds up to capacity tokens and refills them at rate tokens
+// per second; every request takes one.
+type tokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+	used     time.Time
+}
+
+func newTokenBucket(limit, burst int, period time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(burst),
+		rate:     float64(limit) / period.Seconds(),
+		tokens:   float64(burst),
+		last:     now,
+		used:     now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) decision {
+	b.refill(now)
+	b.used = now
+	if b.tokens < 1 {
+		d := b.state()
+		d.retryAfter = seconds((1 - b.tokens) / b.rate)
+		return d
+	}
+	b.tokens--
+	d := b.state()
+	d.allowed = true
+	return d
+}
+
+func (b *tokenBucket) peek(now time.Time) decision {
+	b.refill(now)
+	d := b.state()
+	d.allowed = b.tokens >= 1
+	return d
+}
+
+func (b *tokenBucket) state() decision {
+	return decision{
+		limit:     int(b.capacity),
+		remaining: int(b.tokens),
+		reset:     seconds((b.capacity - b.tokens) / b.rate),
+	}
+}
+
+func (b *tokenBucket) lastUsed() time.Time {
+	return b.used
+}
+
+// slidingWindow approximates the number of requests in the last period from
+// the counts of the current and the previous fixed window, weighting the
+// previous one by how much of it still overlaps.
+type slidingWindow struct {
+	limit    int
+	period   time.Duration
+	start    time.Time
+	current  int
+	previous int
+	last     time.Time
+}
+
+func newSlidingWindow(limit int, period time.Duration, now time.Time) *slidingWindow {
+	return &slidingWindow{limit: limit, period: period, start: now.Truncate(period), last: now}
+}
+
+func (w *slidingWindow) advance(now time.Time) {
+	start := now.Truncate(w.period)
+	if !start.After(w.start) {
+		return
+	}
+	if start.Sub(w.start) == w.period {
+		w.previous = w.current
+	} else {
+		w.previous = 0
+	}
+	w.current = 0
+	w.start = start
+}
+
+// estimate returns the weighted request count at now.
+func (w *slidingWindow) estimate(now time.Time) float64 {
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.period)
+	return float64(w.previous)*overlap + float64(w.current)
+}
+
+func (w *slidingWindow) take(now time.Time) decision {
+	w.advance(now)
+	w.last = now
+	if w.estimate(now)+1 > float64(w.limit) {
+		d := w.state(now)
+		d.retryAfter = w.retryAfter(now)
+		return d
+	}
+	w.current++
+	d := w.state(now)
+	d.allowed = true
+	return d
+}
+
+func (w *slidingWindow) peek(now time.Time) decision {
+	w.advance(now)
+	d := w.state(now)
+	d.allowed = w.estimate(now)+1 <= float64(w.limit)
+	return d
+}
+
+func (w *slidingWindow) state(now time.Time) decision {
+	return decision{
+		limit:     w.limit,
+		remaining: max(0, w.limit-int(math.Ceil(w.estimate(now)))),
+		// Both windows have slid out one period after the current one
+		// ends.
+		reset: w.start.Add(2 * w.period).Sub(now),
+	}
+}
+
+// retryAfter is the time until the weighted count leaves room for one more
+// request, assuming no other requests arrive meanwhile.
+func (w *slidingWindow) retryAfter(now time.Time) time.Duration {
+	free := float64(w.limit - 1)
+	if float64(w.current) <= free {
+		// Wait for the previous window to slide out far enough.
+		overlap := (free - float64(w.current)) / float64(w.previous)
+		return w.start.Add(w.slideOut(overlap)).Sub(now)
+	}
+	// The current window alone is full: wait until it is the previous one
+	// and has slid out far enough.
+	overlap := free / float64(w.current)
+	return w.start.Add(w.period).Add(w.slideOut(overlap)).Sub(now)
+}
+
+// slideOut is the time into a window at which the previous one overlaps it
+// by no more than overlap, rounded up so the request then fits.
+func (w *slidingWindow) slideOut(overlap float64) time.Duration {
+	return time.Duration(math.Ceil((1 - overlap) * float64(w.period)))
+}
+
+func (w *slidingWindow) lastUsed() time.Time {
+	return w.last
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	// 10 requests per second with room for a burst of 5.
+	b := newTokenBucket(10, 5, time.Second, start)
+
+	for i := 0; i < 5; i++ {
+		if d := b.take(start); !d.allowed || d.remaining != 4-i {
+			t.Fatalf("burst request %d: allowed %v, remaining %d", i+1, d.allowed, d.remaining)
+		}
+	}
+	d := b.take(start)
+	if d.allowed {
+		t.Fatal("request past the burst allowed")
+	}
+	if d.retryAfter != 100*time.Millisecond {
+		t.Fatalf("retryAfter %v, want 100ms for one token at 10/s", d.retryAfter)
+	}
+	if d.reset != 500*time.Millisecond {
+		t.Fatalf("reset %v, want 500ms to refill 5 tokens", d.reset)
+	}
+
+	if b.take(start.Add(99 * time.Millisecond)).allowed {
+		t.Fatal("allowed before a token was refilled")
+	}
+	if !b.take(start.Add(200 * time.Millisecond)).allowed {
+		t.Fatal("refilled token refused")
+	}
+
+	// A long pause refills up to the burst and no further.
+	later := start.Add(time.Minute)
+	if d := b.peek(later); d.remaining != 5 || d.limit != 5 {
+		t.Fatalf("after a pause: remaining %d of %d, want 5 of 5", d.remaining, d.limit)
+	}
+}
+
+func TestSlidingWindowLimit(t *testing.T) {
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+	w := newSlidingWindow(10, time.Minute, start)
+
+	for i := 0; i < 10; i++ {
+		if !w.take(start.Add(time.Duration(i) * time.Second)).allowed {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	if w.take(start.Add(59 * time.Second)).allowed {
+		t.Fatal("request past the limit allowed")
+	}
+
+	// Halfway through the next window half of the previous one still
+	// counts, leaving room for 5.
+	half := start.Add(90 * time.Second)
+	for i := 0; i < 5; i++ {
+		if !w.take(half).allowed {
+			t.Fatalf("request %d in the next window refused", i+1)
+		}
+	}
+	if d := w.take(half); d.allowed || d.remaining != 0 {
+		t.Fatalf("allowed %v with remaining %d, want a rejection", d.allowed, d.remaining)
+	}
+
+	// A window without requests in between forgets the old counts.
+	if d := w.peek(start.Add(3 * time.Minute)); !d.allowed || d.remaining != 10 {
+		t.Fatalf("after an idle window: allowed %v, remaining %d", d.allowed, d.remaining)
+	}
+}
+
+func TestSlidingWindowRetryAfter(t *testing.T) {
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	tests := []struct {
+		name string
+		// previous and current are the requests of the previous window
+		// and of the current one, made at offset into the current window.
+		previous, current int
+		offset            time.Duration
+		want              time.Duration
+	}{
+		// The current window alone is full: it must become the previous
+		// one and slide out by a tenth.
+		{"current full", 0, 10, 0, 66 * time.Second},
+		// 10*overlap + 5 must drop to 9: overlap 0.4 at 36s.
+		{"previous sliding out", 10, 5, 30 * time.Second, 6 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newSlidingWindow(10, time.Minute, start.Add(-time.Minute))
+			for i := 0; i < tt.previous; i++ {
+				w.take(start.Add(-time.Second))
+			}
+			now := start.Add(tt.offset)
+			for i := 0; i < tt.current; i++ {
+				if !w.take(now).allowed {
+					t.Fatalf("setup request %d refused", i+1)
+				}
+			}
+
+			d := w.take(now)
+			if d.allowed {
+				t.Fatal("request over the limit allowed")
+			}
+			if d.retryAfter != tt.want {
+				t.Fatalf("retryAfter %v, want %v", d.retryAfter, tt.want)
+			}
+			if w.peek(now.Add(d.retryAfter - time.Second)).allowed {
+				t.Fatal("allowed a second before retryAfter")
+			}
+			if !w.take(now.Add(d.retryAfter)).allowed {
+				t.Fatal("refused at retryAfter")
+			}
+		})
+	}
+}
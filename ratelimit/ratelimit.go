@@ -0,0 +1,385 @@
+// Package ratelimit limits requests per client IP, header value, API key or
+// route with token buckets or sliding windows. Rejected requests get 429
+// with Retry-After, and every limited response carries RateLimit-* headers.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"reverseproxy.com/metrics"
+	"reverseproxy.com/proxy"
+)
+
+type Rule struct {
+	Name string
+	// Key selects what is counted: "client-ip", "api-key", "route" or
+	// "header:<name>".
+	Key string
+	// Algorithm is "token-bucket" or "sliding-window".
+	Algorithm string
+	// Limit requests are allowed per Period.
+	Limit  int
+	Period time.Duration
+	// Burst is the token bucket size, Limit when 0.
+	Burst int
+	// Routes restricts the rule to these routes; empty applies it to all.
+	Routes []string
+}
+
+type Options struct {
+	Rules []Rule
+	// APIKeyHeader carries the key of "api-key" rules.
+	APIKeyHeader string
+	// IdleTimeout drops the state of keys without requests for that long.
+	IdleTimeout time.Duration
+}
+
+var (
+	ErrUnknownRule = errors.New("no such rate limit rule")
+	ErrUnknownKey  = errors.New("key has no rate limit state")
+)
+
+var rejectedTotal = metrics.NewCounterVec("proxy_rate_limited_total",
+	"Requests rejected by a rate limit rule, by rule and route.",
+	"rule", "route")
+
+func init() {
+	metrics.Default.MustRegister(rejectedTotal)
+}
+
+type Limiter struct {
+	mux   sync.RWMutex
+	opts  Options
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	mux     sync.Mutex
+	entries map[string]counter
+}
+
+func New(opts Options) *Limiter {
+	l := &Limiter{}
+	l.Configure(opts)
+	return l
+}
+
+// Configure replaces the rules. A rule that keeps its name and settings
+// keeps the state of its keys.
+func (l *Limiter) Configure(opts Options) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	existing := make(map[string]*rule, len(l.rules))
+	for _, r := range l.rules {
+		existing[r.Name] = r
+	}
+
+	rules := make([]*rule, 0, len(opts.Rules))
+	for _, cfg := range opts.Rules {
+		if cfg.Burst <= 0 {
+			cfg.Burst = cfg.Limit
+		}
+		if r, ok := existing[cfg.Name]; ok && reflect.DeepEqual(r.Rule, cfg) {
+			rules = append(rules, r)
+			continue
+		}
+		rules = append(rules, &rule{Rule: cfg, entries: make(map[string]counter)})
+	}
+
+	l.opts = opts
+	l.rules = rules
+}
+
+// Start evicts idle keys until ctx ends.
+func (l *Limiter) Start(ctx context.Context) {
+	for {
+		l.mux.RLock()
+		idleTimeout := l.opts.IdleTimeout
+		l.mux.RUnlock()
+
+		timer := time.NewTimer(idleTimeout / 2)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			l.evict(now, idleTimeout)
+		}
+	}
+}
+
+func (l *Limiter) evict(now time.Time, idleTimeout time.Duration) {
+	l.mux.RLock()
+	rules := l.rules
+	l.mux.RUnlock()
+
+	for _, r := range rules {
+		r.mux.Lock()
+		for key, c := range r.entries {
+			if now.Sub(c.lastUsed()) >= idleTimeout {
+				delete(r.entries, key)
+			}
+		}
+		r.mux.Unlock()
+	}
+}
+
+// Wrap applies the rules covering route before next. A nil Limiter returns
+// next unchanged.
+func (l *Limiter) Wrap(route string, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, r, route) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow counts the request against every applicable rule and rejects it on
+// the first rule over its limit. The RateLimit-* headers describe the rule
+// closest to its limit, or the one that rejected the request.
+func (l *Limiter) allow(w http.ResponseWriter, r *http.Request, route string) bool {
+	l.mux.RLock()
+	rules := l.rules
+	apiKeyHeader := l.opts.APIKeyHeader
+	l.mux.RUnlock()
+
+	now := time.Now()
+	var tightest *rule
+	var tightestDecision decision
+	for _, rl := range rules {
+		if len(rl.Routes) > 0 && !slices.Contains(rl.Routes, route) {
+			continue
+		}
+		key, ok := rl.requestKey(r, route, apiKeyHeader)
+		if !ok {
+			continue
+		}
+
+		d := rl.take(key, now)
+		if !d.allowed {
+			setHeaders(w.Header(), rl, d)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.retryAfter)))
+			rejectedTotal.WithLabelValues(rl.Name, route).Inc()
+			proxy.WriteError(w, r, "429 Too Many Requests", http.StatusTooManyRequests)
+			return false
+		}
+		if tightest == nil || d.remaining < tightestDecision.remaining {
+			tightest, tightestDecision = rl, d
+		}
+	}
+
+	if tightest != nil {
+		setHeaders(w.Header(), tightest, tightestDecision)
+	}
+	return true
+}
+
+// requestKey returns the key the request is counted under. Requests without
+// the header or API key a rule counts by are not limited by it. API keys are
+// only kept hashed.
+func (r *rule) requestKey(req *http.Request, route, apiKeyHeader string) (string, bool) {
+	switch {
+	case r.Key == "client-ip":
+		return proxy.ClientIP(req), true
+	case r.Key == "route":
+		return route, true
+	case r.Key == "api-key":
+		value := req.Header.Get(apiKeyHeader)
+		return hashKey(value), value != ""
+	case strings.HasPrefix(r.Key, "header:"):
+		value := req.Header.Get(strings.TrimPrefix(r.Key, "header:"))
+		return value, value != ""
+	}
+	return "", false
+}
+
+func hashKey(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (r *rule) take(key string, now time.Time) decision {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	c, ok := r.entries[key]
+	if !ok {
+		c = r.newCounter(now)
+		r.entries[key] = c
+	}
+	return c.take(now)
+}
+
+func (r *rule) newCounter(now time.Time) counter {
+	if r.Algorithm == "sliding-window" {
+		return newSlidingWindow(r.Limit, r.Period, now)
+	}
+	return newTokenBucket(r.Limit, r.Burst, r.Period, now)
+}
+
+func (r *rule) policy() string {
+	policy := fmt.Sprintf("%d;w=%d", r.Limit, ceilSeconds(r.Period))
+	if r.Algorithm == "token-bucket" && r.Burst != r.Limit {
+		policy += fmt.Sprintf(";burst=%d", r.Burst)
+	}
+	return policy
+}
+
+func setHeaders(h http.Header, r *rule, d decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+	h.Set("RateLimit-Policy", r.policy())
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type RuleStatus struct {
+	Name       string   `json:"name"`
+	Key        string   `json:"key"`
+	Algorithm  string   `json:"algorithm"`
+	Limit      int      `json:"limit"`
+	Period     string   `json:"period"`
+	Burst      int      `json:"burst,omitempty"`
+	Routes     []string `json:"routes,omitempty"`
+	ActiveKeys int      `json:"active_keys"`
+}
+
+type KeyStatus struct {
+	Key          string    `json:"key"`
+	Limit        int       `json:"limit"`
+	Remaining    int       `json:"remaining"`
+	ResetSeconds int       `json:"reset_seconds"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+func (l *Limiter) Rules() []RuleStatus {
+	l.mux.RLock()
+	rules := l.rules
+	l.mux.RUnlock()
+
+	statuses := make([]RuleStatus, 0, len(rules))
+	for _, r := range rules {
+		status := RuleStatus{
+			Name:      r.Name,
+			Key:       r.Key,
+			Algorithm: r.Algorithm,
+			Limit:     r.Limit,
+			Period:    r.Period.String(),
+			Routes:    r.Routes,
+		}
+		if r.Algorithm == "token-bucket" {
+			status.Burst = r.Burst
+		}
+		r.mux.Lock()
+		status.ActiveKeys = len(r.entries)
+		r.mux.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (l *Limiter) rule(name string) (*rule, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	for _, r := range l.rules {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, ErrUnknownRule
+}
+
+// lookupKey turns the key an admin asks for into the stored one: API keys
+// are given in clear and stored hashed, though their hash is accepted too.
+func (r *rule) lookupKey(key string) string {
+	if r.Key == "api-key" {
+		if _, ok := r.entries[key]; !ok {
+			return hashKey(key)
+		}
+	}
+	return key
+}
+
+// Keys returns the state of every tracked key of a rule, sorted by key.
+func (l *Limiter) Keys(ruleName string) ([]KeyStatus, error) {
+	r, err := l.rule(ruleName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	statuses := make([]KeyStatus, 0, len(r.entries))
+	for key, c := range r.entries {
+		statuses = append(statuses, keyStatus(key, c, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses, nil
+}
+
+func (l *Limiter) Key(ruleName, key string) (KeyStatus, error) {
+	r, err := l.rule(ruleName)
+	if err != nil {
+		return KeyStatus{}, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	key = r.lookupKey(key)
+	c, ok := r.entries[key]
+	if !ok {
+		return KeyStatus{}, ErrUnknownKey
+	}
+	return keyStatus(key, c, time.Now()), nil
+}
+
+// Reset forgets the counters of a key, which starts over at its full limit.
+func (l *Limiter) Reset(ruleName, key string) error {
+	r, err := l.rule(ruleName)
+	if err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	key = r.lookupKey(key)
+	if _, ok := r.entries[key]; !ok {
+		return ErrUnknownKey
+	}
+	delete(r.entries, key)
+	return nil
+}
+
+func keyStatus(key string, c counter, now time.Time) KeyStatus {
+	d := c.peek(now)
+	return KeyStatus{
+		Key:          key,
+		Limit:        d.limit,
+		Remaining:    d.remaining,
+		ResetSeconds: ceilSeconds(d.reset),
+		LastSeen:     c.lastUsed(),
+	}
+}
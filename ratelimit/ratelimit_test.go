@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"reverseproxy.com/proxy"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func serve(handler http.Handler, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.test/", nil)
+	r.RemoteAddr = remoteAddr
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestRejectionHeaders(t *testing.T) {
+	l := New(Options{Rules: []Rule{
+		{Name: "per-ip", Key: "client-ip", Algorithm: "token-bucket", Limit: 2, Period: time.Minute},
+	}})
+	handler := l.Wrap("", ok)
+
+	w := serve(handler, "192.0.2.1:1234", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "30",
+		"RateLimit-Policy":    "2;w=60",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("first request: %s %q, want %q", name, got, want)
+		}
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After set on an allowed request")
+	}
+
+	serve(handler, "192.0.2.1:1234", nil)
+	w = serve(handler, "192.0.2.1:1234", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want 429", w.Code)
+	}
+	for name, want := range map[string]string{
+		// One token comes back every 30s.
+		"Retry-After":         "30",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("rejected request: %s %q, want %q", name, got, want)
+		}
+	}
+
+	if w := serve(handler, "192.0.2.2:1234", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("other client: status %d", w.Code)
+	}
+}
+
+func TestRuleAndKeySelection(t *testing.T) {
+	l := New(Options{
+		APIKeyHeader: "X-API-Key",
+		Rules: []Rule{
+			{Name: "api-ip", Key: "client-ip", Algorithm: "token-bucket", Limit: 1, Period: time.Minute, Routes: []string{"api"}},
+			{Name: "api-key", Key: "api-key", Algorithm: "sliding-window", Limit: 100, Period: time.Minute},
+			{Name: "tenant", Key: "header:X-Tenant", Algorithm: "token-bucket", Limit: 100, Period: time.Minute},
+		},
+	})
+	resolver, err := proxy.NewClientIPResolver([]string{"10.0.0.0/8"}, "x-forwarded-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := resolver.Middleware(l.Wrap("api", ok))
+	web := resolver.Middleware(l.Wrap("web", ok))
+
+	forwarded := http.Header{"X-Forwarded-For": {"203.0.113.5"}}
+	if w := serve(api, "10.0.0.1:1234", forwarded); w.Code != http.StatusNoContent {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	// The trusted proxy forwards for the same client, which is now limited.
+	if w := serve(api, "10.0.0.2:1234", forwarded); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("same client through another proxy: status %d, want 429", w.Code)
+	}
+	// An untrusted peer cannot borrow the client's address, nor is it
+	// charged for it.
+	if w := serve(api, "198.51.100.7:1234", forwarded); w.Code != http.StatusNoContent {
+		t.Fatalf("untrusted peer: status %d", w.Code)
+	}
+	// The rule is restricted to the api route.
+	if w := serve(web, "10.0.0.1:1234", forwarded); w.Code != http.StatusNoContent {
+		t.Fatalf("web route: status %d", w.Code)
+	}
+
+	keys, err := l.Keys("api-ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Key != "198.51.100.7" || keys[1].Key != "203.0.113.5" {
+		t.Fatalf("api-ip keys %+v, want the untrusted peer and the forwarded client", keys)
+	}
+
+	// Requests without the API key or header are not counted by those
+	// rules; API keys are stored hashed but looked up in clear.
+	if keys, _ := l.Keys("tenant"); len(keys) != 0 {
+		t.Fatalf("tenant keys %+v without an X-Tenant header", keys)
+	}
+	serve(web, "192.0.2.1:1234", http.Header{"X-Api-Key": {"secret"}, "X-Tenant": {"acme"}})
+	status, err := l.Key("api-key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Key == "secret" || status.Remaining != 99 {
+		t.Fatalf("api-key state %+v, want a hashed key with 99 remaining", status)
+	}
+	if _, err := l.Key("tenant", "acme"); err != nil {
+		t.Fatalf("tenant key: %v", err)
+	}
+	if _, err := l.Key("nope", "acme"); err != ErrUnknownRule {
+		t.Fatalf("unknown rule: %v", err)
+	}
+}
+
+func TestIdleKeyEviction(t *testing.T) {
+	l := New(Options{Rules: []Rule{
+		{Name: "per-ip", Key: "client-ip", Algorithm: "token-bucket", Limit: 10, Period: time.Minute},
+		{Name: "per-route", Key: "route", Algorithm: "sliding-window", Limit: 10, Period: time.Minute},
+	}})
+	now := time.Now()
+	for _, r := range l.rules {
+		r.take("idle", now)
+		r.take("active", now.Add(50*time.Second))
+	}
+
+	l.evict(now.Add(time.Minute), time.Minute)
+
+	for _, status := range l.Rules() {
+		if status.ActiveKeys != 1 {
+			t.Errorf("rule %s has %d keys, want 1", status.Name, status.ActiveKeys)
+		}
+		if _, err := l.Key(status.Name, "idle"); err != ErrUnknownKey {
+			t.Errorf("rule %s: idle key not evicted: %v", status.Name, err)
+		}
+		if _, err := l.Key(status.Name, "active"); err != nil {
+			t.Errorf("rule %s: active key evicted: %v", status.Name, err)
+		}
+	}
+}
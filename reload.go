@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverseproxy.com/admin"
+	"reverseproxy.com/config"
+	"reverseproxy.com/health"
+	"reverseproxy.com/proxy"
+	"reverseproxy.com/ratelimit"
+)
+
+// proxyRuntime owns everything that can change on a configuration reload:
+// the backend pools, their balancers and health checkers, the rate limits
+// and the router.
+// Requests are served by whatever router was stored last, so a reload swaps
+// routes and strategies atomically while in-flight requests finish on the
+// previous one.
+type proxyRuntime struct {
+	mux          sync.Mutex
+	ctx          context.Context
+	configPath   string
+	adminAPI     *admin.AdminAPI
+	defaultPool  *proxy.ServerPool
+	current      config.ProxyConfig
+	stickySecret []byte
+	pools        map[string]*poolRuntime
+	router       atomic.Pointer[proxy.Router]
+	limiter      *ratelimit.Limiter
+
+	reportMux  sync.Mutex
+	lastReport *admin.ReloadReport
+}
+
+// poolRuntime is the running state of the default pool ("") or of a route.
+type poolRuntime struct {
+	pool        *proxy.ServerPool
+	balancer    proxy.LoadBalancer
+	sticky      stickySettings
+	check       healthSettings
+	stopChecker context.CancelFunc
+	strategy    string
+	timeout     time.Duration
+	retry       config.RetryConfig
+	budget      *proxy.RetryBudget
+}
+
+type stickySettings struct {
+	enabled    bool
+	ttl        time.Duration
+	mode       string
+	cookieName string
+	secret     string
+	appCookie  string
+}
+
+type healthSettings struct {
+	interval time.Duration
+	timeout  time.Duration
+	method   string
+	check    config.HealthCheckConfig
+}
+
+func newProxyRuntime(ctx context.Context, configPath string, adminAPI *admin.AdminAPI, defaultPool *proxy.ServerPool) *proxyRuntime {
+	return &proxyRuntime{
+		ctx:         ctx,
+		configPath:  configPath,
+		adminAPI:    adminAPI,
+		defaultPool: defaultPool,
+		pools:       make(map[string]*poolRuntime),
+	}
+}
+
+func (rt *proxyRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.router.Load().ServeHTTP(w, r)
+}
+
+// Reload re-reads and validates the configuration file, including PROXY_*
+// overrides, and applies it to the
+// running pools. An invalid file is rejected and the running configuration
+// stays in place.
+func (rt *proxyRuntime) Reload(trigger string) admin.ReloadReport {
+	report := admin.ReloadReport{Trigger: trigger, Time: time.Now(), Changes: []string{}}
+
+	configuration, err := config.LoadConfiguration(rt.configPath)
+	if err != nil {
+		report.Error = err.Error()
+		log.Printf("Reload (%s) rejected, keeping the running configuration: %v", trigger, err)
+	} else {
+		rt.mux.Lock()
+		report.RestartRequired = restartRequired(rt.current, configuration)
+		keepListenerSettings(&configuration, rt.current)
+		report.Changes = append(report.Changes, rt.apply(configuration)...)
+		rt.mux.Unlock()
+
+		report.Success = true
+		log.Printf("Reload (%s) applied %d change(s)", trigger, len(report.Changes))
+		for _, change := range report.Changes {
+			log.Printf("  %s", change)
+		}
+		for _, field := range report.RestartRequired {
+			log.Printf("Warning: %s changed but only takes effect after a restart", field)
+		}
+	}
+
+	rt.reportMux.Lock()
+	rt.lastReport = &report
+	rt.reportMux.Unlock()
+	return report
+}
+
+func (rt *proxyRuntime) LastReload() (admin.ReloadReport, bool) {
+	rt.reportMux.Lock()
+	defer rt.reportMux.Unlock()
+	if rt.lastReport == nil {
+		return admin.ReloadReport{}, false
+	}
+	return *rt.lastReport, true
+}
+
+// Start applies the initial configuration.
+func (rt *proxyRuntime) Start(configuration config.ProxyConfig) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+
+	for _, change := range rt.apply(configuration) {
+		fmt.Println(change)
+	}
+}
+
+// apply reconciles the running pools with the configuration and stores a
+// new router. The caller holds rt.mux.
+func (rt *proxyRuntime) apply(configuration config.ProxyConfig) []string {
+	var changes []string
+	stickyOptions := rt.stickyOptions(configuration)
+
+	// Counters survive a reload for the rules that did not change.
+	if rt.limiter == nil {
+		rt.limiter = ratelimit.New(rateLimitOptions(configuration.RateLimit))
+		go rt.limiter.Start(rt.ctx)
+	} else if !reflect.DeepEqual(rt.current.RateLimit, configuration.RateLimit) {
+		rt.limiter.Configure(rateLimitOptions(configuration.RateLimit))
+		changes = append(changes, "rate limits updated")
+	}
+
+	top, topChanges := rt.syncPool("", configuration.BackendsConfig, poolOptions(configuration, configuration.HashVirtualNodes, configuration.HashLoadFactor),
+		stickySettingsFor(configuration, configuration.EnableStickySessions, stickyOptions), healthSettingsFor(configuration, configuration.HealthCheck),
+		configuration.Strategy, configuration.Backend_timeout, stickyOptions)
+	changes = append(changes, topChanges...)
+	topRetry, retryChanges := top.retryOptions("default pool", configuration.Retry)
+	changes = append(changes, retryChanges...)
+
+	// The default pool always serves unmatched requests, even while it is
+	// empty, so backends added through the admin API take traffic at once.
+	// An empty pool answers 503.
+	fallback := proxy.ProxyHandler(top.balancer, proxy.HandlerOptions{
+		Timeout:       configuration.Backend_timeout,
+		StickyEnabled: configuration.EnableStickySessions,
+		Strategy:      configuration.Strategy,
+		HashKey:       configuration.HashKey,
+		Forwarding:    forwardingOptions(configuration.Forwarding),
+		Retry:         topRetry,
+	})
+	router := proxy.NewRouter(rt.limiter.Wrap("", fallback))
+
+	configured := map[string]bool{"": true}
+	for _, routeConfig := range configuration.Routes {
+		configured[routeConfig.Name] = true
+		routeSticky := stickyOptions
+		routeSticky.Route = routeConfig.Name
+		routeRuntime, routeChanges := rt.syncPool(routeConfig.Name, routeConfig.Backends, poolOptions(configuration, routeConfig.HashVirtualNodes, routeConfig.HashLoadFactor),
+			stickySettingsFor(configuration, routeConfig.EnableStickySessions, routeSticky), healthSettingsFor(configuration, routeConfig.HealthCheck),
+			routeConfig.Strategy, routeConfig.Timeout, routeSticky)
+		changes = append(changes, routeChanges...)
+		routeRetry, retryChanges := routeRuntime.retryOptions("route "+routeConfig.Name, routeConfig.Retry)
+		changes = append(changes, retryChanges...)
+
+		route := &proxy.Route{
+			Name:       routeConfig.Name,
+			Host:       routeConfig.Host,
+			PathPrefix: routeConfig.PathPrefix,
+			Methods:    routeConfig.Methods,
+			Headers:    routeConfig.Headers,
+			Handler: rt.limiter.Wrap(routeConfig.Name, proxy.ProxyHandler(routeRuntime.balancer, proxy.HandlerOptions{
+				Timeout:       routeConfig.Timeout,
+				StickyEnabled: routeConfig.EnableStickySessions,
+				Strategy:      routeConfig.Strategy,
+				HashKey:       routeConfig.HashKey,
+				Forwarding:    forwardingOptions(routeConfig.Forwarding),
+				Route:         routeConfig.Name,
+				Retry:         routeRetry,
+			})),
+		}
+		if routeConfig.PathRegex != "" {
+			route.PathRegex = regexp.MustCompile(routeConfig.PathRegex)
+		}
+		router.AddRoute(route)
+	}
+
+	for name, pr := range rt.pools {
+		if configured[name] {
+			continue
+		}
+		pr.stop()
+		rt.adminAPI.RemoveRoutePool(name)
+		delete(rt.pools, name)
+		changes = append(changes, fmt.Sprintf("route %s: removed", name))
+	}
+
+	if rt.router.Load() != nil {
+		if !reflect.DeepEqual(routeMatchers(rt.current.Routes), routeMatchers(configuration.Routes)) {
+			changes = append(changes, "routing rules updated")
+		}
+		if !reflect.DeepEqual(rt.current.Forwarding, configuration.Forwarding) {
+			changes = append(changes, "forwarding options updated")
+		}
+	}
+
+	rt.router.Store(router)
+	rt.current = configuration
+	return changes
+}
+
+// syncPool creates the pool of a route on its first configuration and
+// reconciles it afterwards. The balancer is only rebuilt when the sticky
+// settings change so existing sessions survive, and the health checker only
+// restarts when its settings change.
+func (rt *proxyRuntime) syncPool(name string, backends []config.BackendConfig, opts proxy.PoolOptions, sticky stickySettings, check healthSettings, strategy string, timeout time.Duration, stickyOptions proxy.StickyOptions) (*poolRuntime, []string) {
+	label := "default pool"
+	if name != "" {
+		label = "route " + name
+	}
+
+	var changes []string
+	specs := backendSpecs(backends)
+
+	pr, exists := rt.pools[name]
+	if !exists {
+		pool := rt.defaultPool
+		if name != "" {
+			pool = &proxy.ServerPool{}
+		}
+		pool.HashVirtualNodes = opts.HashVirtualNodes
+		pool.HashLoadFactor = opts.HashLoadFactor
+		pool.Transport = opts.Transport
+		pool.Outlier = opts.Outlier
+		pool.Breaker = opts.Breaker
+
+		// Set after the initial backends so they start at full weight; only
+		// backends added later or recovering from failure ramp up. The pool
+		// does not serve requests yet.
+		if name != "" && rt.router.Load() != nil {
+			changes = append(changes, "added")
+		}
+		initial := opts
+		initial.SlowStart = proxy.SlowStartOptions{}
+		changes = append(changes, pool.Reconcile(specs, initial)...)
+		pool.SlowStart = opts.SlowStart
+
+		pr = &poolRuntime{pool: pool, strategy: strategy, timeout: timeout}
+		rt.pools[name] = pr
+		if name != "" {
+			rt.adminAPI.AddRoutePool(name, pool)
+		}
+	} else {
+		changes = append(changes, pr.pool.Reconcile(specs, opts)...)
+		if pr.strategy != strategy {
+			changes = append(changes, fmt.Sprintf("strategy changed from %s to %s", pr.strategy, strategy))
+		}
+		if pr.timeout != timeout {
+			changes = append(changes, fmt.Sprintf("timeout changed from %v to %v", pr.timeout, timeout))
+		}
+		pr.strategy = strategy
+		pr.timeout = timeout
+	}
+
+	if pr.balancer == nil || pr.sticky != sticky {
+		if closer, ok := pr.balancer.(*proxy.StickySessionPool); ok {
+			closer.Close()
+			changes = append(changes, "sticky sessions reset")
+		}
+		pr.balancer = buildLoadBalancer(pr.pool, sticky.enabled, sticky.ttl, stickyOptions, strategy)
+		pr.sticky = sticky
+	}
+
+	if pr.stopChecker == nil || !reflect.DeepEqual(pr.check, check) {
+		if pr.stopChecker != nil {
+			pr.stopChecker()
+			changes = append(changes, "health checks restarted")
+		}
+		checkCtx, stop := context.WithCancel(rt.ctx)
+		checker := health.NewHealthChecker(pr.pool, check.interval, check.timeout, check.method, healthCheckOptions(check.check))
+		go checker.Start(checkCtx)
+		pr.stopChecker = stop
+		pr.check = check
+	}
+
+	for i := range changes {
+		changes[i] = label + ": " + changes[i]
+	}
+	return pr, changes
+}
+
+// retryOptions converts the retry settings of the pool. The retry budget is
+// created once and reconfigured afterwards, so the recent request counts it
+// limits retries by survive reloads.
+func (pr *poolRuntime) retryOptions(label string, retry config.RetryConfig) (proxy.RetryOptions, []string) {
+	var changes []string
+	if pr.budget == nil {
+		pr.budget = proxy.NewRetryBudget(retry.BudgetRatio, retry.BudgetMinPerSecond)
+	} else {
+		if !reflect.DeepEqual(pr.retry, retry) {
+			changes = append(changes, label+": retry options updated")
+		}
+		pr.budget.Configure(retry.BudgetRatio, retry.BudgetMinPerSecond)
+	}
+	pr.retry = retry
+
+	return proxy.RetryOptions{
+		MaxRetries:     retry.MaxRetries,
+		OnConnectError: retry.RetriesOn("connect-error"),
+		OnTimeout:      retry.RetriesOn("timeout"),
+		Statuses:       retry.Statuses,
+		PerTryTimeout:  retry.PerTryTimeout,
+		Backoff:        retry.Backoff,
+		MaxBackoff:     retry.MaxBackoff,
+		NonIdempotent:  retry.NonIdempotent,
+		MaxBodyBytes:   retry.MaxBodyBytes,
+		Budget:         pr.budget,
+	}, changes
+}
+
+func (pr *poolRuntime) stop() {
+	pr.stopChecker()
+	if sticky, ok := pr.balancer.(*proxy.StickySessionPool); ok {
+		sticky.Close()
+	}
+}
+
+// stickyOptions keeps a generated cookie secret across reloads so affinity
+// cookies stay valid as long as the process runs.
+func (rt *proxyRuntime) stickyOptions(configuration config.ProxyConfig) proxy.StickyOptions {
+	opts := buildStickyOptions(configuration, rt.stickySecret)
+	if configuration.StickyCookieSecret == "" {
+		rt.stickySecret = opts.Secret
+	}
+	return opts
+}
+
+func stickySettingsFor(configuration config.ProxyConfig, enabled bool, opts proxy.StickyOptions) stickySettings {
+	return stickySettings{
+		enabled:    enabled,
+		ttl:        configuration.StickySessionTTL,
+		mode:       opts.Mode,
+		cookieName: opts.CookieName,
+		secret:     string(opts.Secret),
+		appCookie:  opts.AppCookie,
+	}
+}
+
+func healthSettingsFor(configuration config.ProxyConfig, check config.HealthCheckConfig) healthSettings {
+	return healthSettings{
+		interval: configuration.HealthCheckFreq,
+		timeout:  check.Timeout,
+		method:   configuration.HealthCheckMethod,
+		check:    check,
+	}
+}
+
+func poolOptions(configuration config.ProxyConfig, hashVirtualNodes int, hashLoadFactor float64) proxy.PoolOptions {
+	return proxy.PoolOptions{
+		HashVirtualNodes: hashVirtualNodes,
+		HashLoadFactor:   hashLoadFactor,
+		Transport: proxy.TransportOptions{
+			MaxIdleConns:          configuration.Transport.MaxIdleConns,
+			MaxIdleConnsPerHost:   configuration.Transport.MaxIdleConnsPerHost,
+			IdleConnTimeout:       configuration.Transport.IdleConnTimeout,
+			DialTimeout:           configuration.Transport.DialTimeout,
+			KeepAlive:             configuration.Transport.KeepAlive,
+			TLSHandshakeTimeout:   configuration.Transport.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: configuration.Transport.ResponseHeaderTimeout,
+			DisableHTTP2:          configuration.Transport.DisableHTTP2,
+		},
+		Outlier: proxy.OutlierOptions{
+			Enabled:            configuration.OutlierDetection.Enabled,
+			ConsecutiveErrors:  configuration.OutlierDetection.ConsecutiveErrors,
+			ErrorRate:          configuration.OutlierDetection.ErrorRate,
+			MinRequests:        configuration.OutlierDetection.MinRequests,
+			Window:             configuration.OutlierDetection.Window,
+			BaseEjectionTime:   configuration.OutlierDetection.BaseEjectionTime,
+			MaxEjectionTime:    configuration.OutlierDetection.MaxEjectionTime,
+			MaxEjectionPercent: configuration.OutlierDetection.MaxEjectionPercent,
+		},
+		Breaker: proxy.BreakerOptions{
+			Enabled:             configuration.CircuitBreaker.Enabled,
+			ConsecutiveFailures: configuration.CircuitBreaker.ConsecutiveFailures,
+			FailureRate:         configuration.CircuitBreaker.FailureRate,
+			MinRequests:         configuration.CircuitBreaker.MinRequests,
+			Window:              configuration.CircuitBreaker.Window,
+			OpenDuration:        configuration.CircuitBreaker.OpenDuration,
+			HalfOpenRequests:    configuration.CircuitBreaker.HalfOpenRequests,
+		},
+		SlowStart: proxy.SlowStartOptions{
+			Window:           configuration.SlowStart.Window,
+			Aggression:       configuration.SlowStart.Aggression,
+			MinWeightPercent: configuration.SlowStart.MinWeightPercent,
+		},
+	}
+}
+
+func backendSpecs(backendsConfig []config.BackendConfig) []proxy.BackendSpec {
+	var specs []proxy.BackendSpec
+	for _, backendConfig := range backendsConfig {
+		parsedURL, err := url.Parse(backendConfig.URL)
+		if err != nil {
+			log.Printf("Invalid backend URL %s: %v", backendConfig.URL, err)
+			continue
+		}
+		specs = append(specs, proxy.BackendSpec{URL: parsedURL, Weight: backendConfig.Weight})
+	}
+	return specs
+}
+
+func routeMatchers(routes []config.RouteConfig) []config.RouteConfig {
+	var matchers []config.RouteConfig
+	for _, route := range routes {
+		matchers = append(matchers, config.RouteConfig{
+			Name:       route.Name,
+			Host:       route.Host,
+			PathPrefix: route.PathPrefix,
+			PathRegex:  route.PathRegex,
+			Methods:    route.Methods,
+			Headers:    route.Headers,
+		})
+	}
+	return matchers
+}
+
+// restartRequired lists the changed settings that are bound to the
+// listeners, or to the request ID, access log and tracing middlewares
+// wrapped around them, and cannot be applied to a running process.
+func restartRequired(old, new config.ProxyConfig) []string {
+	var fields []string
+	if old.Port != new.Port {
+		fields = append(fields, "port")
+	}
+	if old.Admin_port != new.Admin_port {
+		fields = append(fields, "admin_port")
+	}
+	if old.SSL != new.SSL {
+		fields = append(fields, "ssl")
+	}
+	if old.ProxyProtocol != new.ProxyProtocol {
+		fields = append(fields, "proxy_protocol")
+	}
+	if !slices.Equal(old.TrustedProxies, new.TrustedProxies) {
+		fields = append(fields, "trusted_proxies")
+	}
+	if old.ClientIPHeader != new.ClientIPHeader {
+		fields = append(fields, "client_ip_header")
+	}
+	if !reflect.DeepEqual(old.Admin, new.Admin) {
+		fields = append(fields, "admin")
+	}
+	if !reflect.DeepEqual(old.AccessLog, new.AccessLog) {
+		fields = append(fields, "access_log")
+	}
+	if !reflect.DeepEqual(old.Tracing, new.Tracing) {
+		fields = append(fields, "tracing")
+	}
+	if old.RequestID != new.RequestID {
+		fields = append(fields, "request_id")
+	}
+	return fields
+}
+
+// keepListenerSettings carries the running listener settings over, so they
+// are reported again on the next reload until the process restarts.
+func keepListenerSettings(configuration *config.ProxyConfig, running config.ProxyConfig) {
+	configuration.Port = running.Port
+	configuration.Admin_port = running.Admin_port
+	configuration.SSL = running.SSL
+	configuration.ProxyProtocol = running.ProxyProtocol
+	configuration.TrustedProxies = running.TrustedProxies
+	configuration.ClientIPHeader = running.ClientIPHeader
+	configuration.Admin = running.Admin
+	configuration.AccessLog = running.AccessLog
+	configuration.Tracing = running.Tracing
+	configuration.RequestID = running.RequestID
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds as numbered by OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type statusCode int
+
+const (
+	statusUnset statusCode = 0
+	statusError statusCode = 2
+)
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Span is one timed operation of a trace. All methods are safe to call on a
+// nil *Span, which is what Start returns when tracing is disabled, so
+// callers do not need to check.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  SpanID
+	name    string
+	kind    SpanKind
+	start   time.Time
+	end     time.Time
+
+	mux           sync.Mutex
+	attributes    []attribute
+	status        statusCode
+	statusMessage string
+	ended         bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context whose children spans are started under
+// span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start starts a child of the span in ctx. Without a span in ctx it returns
+// ctx and a nil span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := parent.tracer.newSpan(name, kind, SpanContext{
+		TraceID:    parent.context.TraceID,
+		SpanID:     newSpanID(),
+		Sampled:    parent.context.Sampled,
+		TraceState: parent.context.TraceState,
+	}, parent.context.SpanID)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Context returns the identity of the span to propagate downstream.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, bool, int, int64 or float64 value.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i := range s.attributes {
+		if s.attributes[i].key == key {
+			s.attributes[i].value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, attribute{key: key, value: value})
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.status = statusError
+	s.statusMessage = message
+	s.mux.Unlock()
+}
+
+// End finishes the span and queues it for export if it is sampled. Later
+// calls are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mux.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.exporter.enqueue(s)
+	}
+}
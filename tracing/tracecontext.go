@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	maxTracestateMembers = 32
+	maxTracestateLength  = 512
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) isValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) isValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span that crosses process boundaries in the
+// W3C traceparent and tracestate headers.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// Traceparent formats the context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract reads the trace context of an incoming request. An invalid
+// traceparent is ignored, so the request starts a new trace; an invalid
+// tracestate is dropped on its own.
+func Extract(h http.Header) (SpanContext, bool) {
+	values := h.Values(traceparentHeader)
+	if len(values) != 1 {
+		return SpanContext{}, false
+	}
+	sc, ok := parseTraceparent(strings.TrimSpace(values[0]))
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc.TraceState = parseTracestate(h.Values(tracestateHeader))
+	return sc, true
+}
+
+// Inject writes the trace context to the headers of an outgoing request.
+func Inject(sc SpanContext, h http.Header) {
+	h.Set(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(tracestateHeader, sc.TraceState)
+	} else {
+		h.Del(tracestateHeader)
+	}
+}
+
+// parseTraceparent accepts version-traceid-parentid-flags. Versions above 00
+// may append fields, which are ignored as the specification requires.
+func parseTraceparent(value string) (SpanContext, bool) {
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, false
+	}
+	version := value[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return SpanContext{}, false
+	}
+	if version == "00" && len(value) != 55 {
+		return SpanContext{}, false
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if !decodeHex(value[3:35], sc.TraceID[:]) || !decodeHex(value[36:52], sc.SpanID[:]) {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if !decodeHex(value[53:55], flags[:]) {
+		return SpanContext{}, false
+	}
+	if !sc.TraceID.isValid() || !sc.SpanID.isValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, true
+}
+
+// parseTracestate joins the tracestate headers and returns them unchanged if
+// they are a valid list, or "" otherwise.
+func parseTracestate(values []string) string {
+	var members []string
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(member, "=")
+			if !ok || key == "" || val == "" || strings.ContainsAny(key, " \t") {
+				return ""
+			}
+			members = append(members, member)
+		}
+	}
+
+	state := strings.Join(members, ",")
+	if len(members) > maxTracestateMembers || len(state) > maxTracestateLength {
+		return ""
+	}
+	return state
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeHex(s string, dest []byte) bool {
+	if !isLowerHex(s) {
+		return false
+	}
+	_, err := hex.Decode(dest, []byte(s))
+	return err == nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.isValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.isValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
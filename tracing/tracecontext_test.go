@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+		{"other flags", "00-" + testTraceID + "-" + testSpanID + "-03", true, true},
+		{"future version with more fields", "01-" + testTraceID + "-" + testSpanID + "-01-extra", true, true},
+		{"version 00 with more fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", false, false},
+		{"future version without separator", "01-" + testTraceID + "-" + testSpanID + "-01x", false, false},
+		{"version ff", "ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"upper case", "00-" + strings.ToUpper(testTraceID) + "-" + testSpanID + "-01", false, false},
+		{"zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + testSpanID + "-01", false, false},
+		{"zero span ID", "00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"short", "00-" + testTraceID + "-" + testSpanID[:15] + "-01", false, false},
+		{"wrong separator", "00_" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"not hex", "00-" + testTraceID[:31] + "g-" + testSpanID + "-01", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseTraceparent(tt.value)
+			if ok != tt.valid {
+				t.Fatalf("valid %v, want %v", ok, tt.valid)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tt.sampled {
+				t.Fatalf("got %s %s sampled %v", sc.TraceID, sc.SpanID, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	many := make([]string, maxTracestateMembers+1)
+	for i := range many {
+		many[i] = "k" + strings.Repeat("x", i) + "=v"
+	}
+
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"single", []string{"congo=t61rcWkgMzE"}, "congo=t61rcWkgMzE"},
+		{"joined headers", []string{"congo=t61rcWkgMzE", " rojo=00f067aa0ba902b7 ,"}, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7"},
+		{"member without value", []string{"congo=t61rcWkgMzE,rojo"}, ""},
+		{"key with space", []string{"con go=t61rcWkgMzE"}, ""},
+		{"too many members", []string{strings.Join(many, ",")}, ""},
+		{"too long", []string{"a=" + strings.Repeat("x", maxTracestateLength)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTracestate(tt.values); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractAndInject(t *testing.T) {
+	traceparent := "00-" + testTraceID + "-" + testSpanID + "-01"
+
+	h := http.Header{}
+	h.Set("Traceparent", traceparent)
+	h.Add("Tracestate", "congo=t61rcWkgMzE")
+	sc, ok := Extract(h)
+	if !ok || sc.TraceState != "congo=t61rcWkgMzE" {
+		t.Fatalf("Extract: %+v, %v", sc, ok)
+	}
+
+	out := http.Header{"Tracestate": {"stale=1"}}
+	Inject(sc, out)
+	if out.Get("Traceparent") != traceparent || out.Get("Tracestate") != "congo=t61rcWkgMzE" {
+		t.Fatalf("Inject wrote %v", out)
+	}
+	sc.TraceState = ""
+	Inject(sc, out)
+	if _, ok := out["Tracestate"]; ok {
+		t.Fatalf("Inject kept a stale tracestate: %v", out)
+	}
+
+	// Two traceparent headers are ambiguous and start a new trace.
+	h.Add("Traceparent", traceparent)
+	if _, ok := Extract(h); ok {
+		t.Fatal("Extract accepted two traceparent headers")
+	}
+}
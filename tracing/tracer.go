@@ -0,0 +1,145 @@
+// Package tracing propagates W3C Trace Context headers and exports the
+// proxy's spans to an OpenTelemetry collector over OTLP/HTTP with JSON
+// encoding.
+package tracing
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type Options struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://localhost:4318/v1/traces.
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of new traces recorded. Requests that carry
+	// a traceparent follow its sampled flag.
+	SampleRatio   float64
+	Headers       map[string]string
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+type Tracer struct {
+	sampleRatio float64
+	exporter    *exporter
+}
+
+func New(opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "reverse-proxy"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	return &Tracer{
+		sampleRatio: opts.SampleRatio,
+		exporter:    newExporter(opts),
+	}
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, sc SpanContext, parent SpanID) *Span {
+	return &Span{
+		tracer:  t,
+		context: sc,
+		parent:  parent,
+		name:    name,
+		kind:    kind,
+		start:   time.Now(),
+	}
+}
+
+// Middleware starts a server span for every request, continuing the trace
+// of an incoming traceparent header, and makes it the parent of the spans
+// started further down with Start.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := Extract(r.Header)
+		var parent SpanID
+		if ok {
+			parent = sc.SpanID
+		} else {
+			sc = SpanContext{
+				TraceID: newTraceID(),
+				Sampled: t.sampleRatio >= 1 || rand.Float64() < t.sampleRatio,
+			}
+		}
+		sc.SpanID = newSpanID()
+
+		span := t.newSpan(r.Method, SpanKindServer, sc, parent)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("server.address", r.Host)
+		span.SetAttribute("network.protocol.version", r.Proto)
+		if ua := r.UserAgent(); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		span.SetAttribute("url.scheme", scheme)
+
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.statusCode()
+			span.SetAttribute("http.response.status_code", status)
+			if status >= 500 {
+				span.SetError(http.StatusText(status))
+			}
+			span.End()
+		}()
+
+		next.ServeHTTP(sw, r.WithContext(ContextWithSpan(r.Context(), span)))
+	})
+}
+
+// Shutdown exports the queued spans and stops the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exporter.shutdown(ctx)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 && code >= 200 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) statusCode() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectorSpan is a span as an OTLP/JSON consumer reads it, decoded with
+// the field names of the OTLP JSON mapping.
+type collectorSpan struct {
+	TraceID           string `json:"traceId"`
+	SpanID            string `json:"spanId"`
+	ParentSpanID      string `json:"parentSpanId"`
+	TraceState        string `json:"traceState"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+	Attributes        []struct {
+		Key   string                     `json:"key"`
+		Value map[string]json.RawMessage `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type collectorRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string            `json:"key"`
+				Value map[string]string `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []collectorSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// stubCollector records the export requests it receives.
+type stubCollector struct {
+	*httptest.Server
+	mux      sync.Mutex
+	requests []collectorRequest
+	headers  []http.Header
+}
+
+func newStubCollector(t *testing.T) *stubCollector {
+	t.Helper()
+	c := &stubCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			t.Errorf("export request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req collectorRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("export body %s: %v", body, err)
+		}
+		c.mux.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.mux.Unlock()
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *stubCollector) spans() map[string]collectorSpan {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	spans := make(map[string]collectorSpan)
+	for _, req := range c.requests {
+		for _, resource := range req.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, span := range scope.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func newTestTracer(t *testing.T, c *stubCollector, ratio float64) *Tracer {
+	t.Helper()
+	return New(Options{
+		Endpoint:      c.URL + "/v1/traces",
+		ServiceName:   "test-proxy",
+		SampleRatio:   ratio,
+		Headers:       map[string]string{"Authorization": "Bearer collector-token"},
+		FlushInterval: time.Hour,
+	})
+}
+
+func shutdown(t *testing.T, tracer *Tracer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// upstream starts a client span under the request and returns the headers
+// it would send to a backend.
+func upstream(r *http.Request, status int) http.Header {
+	_, span := Start(r.Context(), "upstream", SpanKindClient)
+	span.SetAttribute("http.response.status_code", status)
+	defer span.End()
+	out := http.Header{}
+	Inject(span.Context(), out)
+	return out
+}
+
+func TestMiddlewareContinuesTraceAndExports(t *testing.T) {
+	collector := newStubCollector(t)
+	tracer := newTestTracer(t, collector, 0)
+
+	var propagated http.Header
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		propagated = upstream(r, http.StatusBadGateway)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.test/orders", nil)
+	r.Header.Set("Traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	r.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	shutdown(t, tracer)
+
+	spans := collector.spans()
+	server, client := spans["GET"], spans["upstream"]
+	if server.SpanID == "" || client.SpanID == "" {
+		t.Fatalf("exported spans %v, want GET and upstream", spans)
+	}
+
+	// The incoming trace continues, and the backend sees the client span.
+	if server.TraceID != testTraceID || server.ParentSpanID != testSpanID || server.TraceState != "congo=t61rcWkgMzE" {
+		t.Errorf("server span %+v does not continue the incoming trace", server)
+	}
+	if client.TraceID != testTraceID || client.ParentSpanID != server.SpanID {
+		t.Errorf("client span %+v is not a child of the server span %s", client, server.SpanID)
+	}
+	if want := "00-" + testTraceID + "-" + client.SpanID + "-01"; propagated.Get("Traceparent") != want {
+		t.Errorf("propagated traceparent %q, want %q", propagated.Get("Traceparent"), want)
+	}
+	if propagated.Get("Tracestate") != "congo=t61rcWkgMzE" {
+		t.Errorf("propagated tracestate %q", propagated.Get("Tracestate"))
+	}
+
+	// The payload follows the OTLP JSON mapping.
+	if server.Kind != int(SpanKindServer) || client.Kind != int(SpanKindClient) {
+		t.Errorf("kinds %d and %d", server.Kind, client.Kind)
+	}
+	if server.Status.Code != int(statusError) || server.Status.Message != "Bad Gateway" {
+		t.Errorf("server status %+v, want an error for the 502", server.Status)
+	}
+	if server.StartTimeUnixNano == "" || server.EndTimeUnixNano < server.StartTimeUnixNano {
+		t.Errorf("server span times %s to %s", server.StartTimeUnixNano, server.EndTimeUnixNano)
+	}
+	attributes := make(map[string]string)
+	for _, attr := range server.Attributes {
+		for _, v := range attr.Value {
+			attributes[attr.Key] = string(v)
+		}
+	}
+	if attributes["url.path"] != `"/orders"` || attributes["http.response.status_code"] != `"502"` {
+		t.Errorf("server attributes %v, want url.path as stringValue and the status as an intValue string", attributes)
+	}
+
+	collector.mux.Lock()
+	defer collector.mux.Unlock()
+	req, header := collector.requests[0], collector.headers[0]
+	if header.Get("Content-Type") != "application/json" || header.Get("Authorization") != "Bearer collector-token" {
+		t.Errorf("export headers %v", header)
+	}
+	resource := req.ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || resource[0].Value["stringValue"] != "test-proxy" {
+		t.Errorf("resource attributes %+v", resource)
+	}
+	if scope := req.ResourceSpans[0].ScopeSpans[0].Scope.Name; scope != "reverseproxy.com/tracing" {
+		t.Errorf("scope %q", scope)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		ratio       float64
+		traceparent string
+		exported    bool
+	}{
+		{"new trace at ratio 1", 1, "", true},
+		{"new trace at ratio 0", 0, "", false},
+		{"sampled parent at ratio 0", 0, "00-" + testTraceID + "-" + testSpanID + "-01", true},
+		{"unsampled parent at ratio 1", 1, "00-" + testTraceID + "-" + testSpanID + "-00", false},
+		{"invalid parent at ratio 1", 1, "00-" + testTraceID + "-" + testSpanID, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := newStubCollector(t)
+			tracer := newTestTracer(t, collector, tt.ratio)
+
+			var propagated http.Header
+			handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				propagated = upstream(r, http.StatusOK)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.test/", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("Traceparent", tt.traceparent)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			shutdown(t, tracer)
+
+			if got := len(collector.spans()) > 0; got != tt.exported {
+				t.Fatalf("exported %v, want %v", got, tt.exported)
+			}
+			// The decision travels downstream either way.
+			sc, ok := Extract(propagated)
+			if !ok || sc.Sampled != tt.exported {
+				t.Fatalf("propagated %v, want sampled %v", propagated, tt.exported)
+			}
+		})
+	}
+}
+
+func TestExportBatches(t *testing.T) {
+	collector := newStubCollector(t)
+	tracer := New(Options{Endpoint: collector.URL + "/v1/traces", SampleRatio: 1, BatchSize: 2, FlushInterval: time.Hour})
+
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 5; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://proxy.test/", nil))
+	}
+	shutdown(t, tracer)
+
+	collector.mux.Lock()
+	defer collector.mux.Unlock()
+	var sizes []int
+	for _, req := range collector.requests {
+		sizes = append(sizes, len(req.ResourceSpans[0].ScopeSpans[0].Spans))
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("batch sizes %v, want [2 2 1]", sizes)
+	}
+}